@@ -80,3 +80,31 @@ func Find(
 ) (cur *mongo.Cursor, err error) {
 	return Db.Collection(collection).Find(context, filter, options...)
 }
+
+func UpdateMany(
+	context context.Context,
+	collection string,
+	filter any,
+	update any,
+	options ...*options.UpdateOptions,
+) (*mongo.UpdateResult, error) {
+	return Db.Collection(collection).UpdateMany(context, filter, update, options...)
+}
+
+func Count(
+	context context.Context,
+	collection string,
+	filter any,
+	options ...*options.CountOptions,
+) (int64, error) {
+	return Db.Collection(collection).CountDocuments(context, filter, options...)
+}
+
+func DeleteMany(
+	context context.Context,
+	collection string,
+	filter any,
+	options ...*options.DeleteOptions,
+) (*mongo.DeleteResult, error) {
+	return Db.Collection(collection).DeleteMany(context, filter, options...)
+}
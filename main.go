@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
 	"github.com/saheemshafi/gin-basic-api/routes"
 	"github.com/saheemshafi/gin-basic-api/utils"
 )
@@ -26,22 +27,41 @@ func main() {
 		Also go routines can be fired so both db and cld start trying to connect at
 		same time and then notify back or log.Fatal when failed
 	*/
-	connectionCh := make(chan string, 6)
+	connectionCh := make(chan string)
+	logged := make(chan struct{})
+
+	// Messages are logged as they arrive so adding an initializer can't
+	// outgrow a buffer and block startup
+	go func() {
+		for msg := range connectionCh {
+			log.Println(msg)
+		}
+
+		close(logged)
+	}()
 
 	db.Connect(connectionCh)
 	defer db.Db.Client().Disconnect(context.TODO())
 
+	if err := models.EnsureIndexes(connectionCh); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := models.BootstrapAdmin(connectionCh); err != nil {
+		log.Fatal(err)
+	}
+
 	utils.InitializeCloudinary(connectionCh)
-	/*
-		Channel needs to be closed first else range will go into infinite loop.
-		Buffered channel is used so it won't get into a deadlock after there is
-		nothing to put into it
-	*/
+	utils.InitializeMailer(connectionCh)
+	utils.InitializeOIDC(connectionCh)
+	utils.InitializeJWT(connectionCh)
+	utils.InitializePasswordPolicy(connectionCh)
+
+	// Closing ends the range above, wait for it so the log stays in order
 	close(connectionCh)
+	<-logged
 
-	for msg := range connectionCh {
-		log.Println(msg)
-	}
+	routes.ResumeJobs()
 
 	app := gin.Default()
 
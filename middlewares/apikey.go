@@ -0,0 +1,136 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	APIKeySource = "api-key"
+	apiKeyHeader = "X-API-Key"
+)
+
+/*
+Authorize variant for routes machine clients may call. Besides the usual
+session token it accepts an X-API-Key carrying the given scope. Routes
+using plain Authorize reject api keys.
+*/
+func AuthorizeScope(scope models.Scope) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("requiredScope", scope)
+		Authorize(ctx)
+	}
+}
+
+/*
+For public routes. Anonymous callers pass through untouched, but a caller
+presenting an api key is held to its scopes like on AuthorizeScope routes.
+*/
+func APIKeyScope(scope models.Scope) gin.HandlerFunc {
+	authorize := AuthorizeScope(scope)
+
+	return func(ctx *gin.Context) {
+		if ctx.GetHeader(apiKeyHeader) == "" {
+			ctx.Next()
+			return
+		}
+
+		authorize(ctx)
+	}
+}
+
+func authorizeAPIKey(ctx *gin.Context, rawKey string) {
+	scopeFromCtx, allowed := ctx.Get("requiredScope")
+
+	if !allowed {
+		forbidden(ctx, "Api keys can't be used for this route")
+		return
+	}
+
+	scope := scopeFromCtx.(models.Scope)
+	prefix, ok := utils.APIKeyPrefix(rawKey)
+
+	if !ok {
+		authFailure(ctx, models.EventAPIKeyRejected, primitive.NilObjectID, "malformed key")
+		unauthorized(ctx, "Invalid api key", "invalid_token")
+		return
+	}
+
+	var key models.APIKey
+
+	if err := db.FindOne(
+		context.Background(),
+		models.APIKeyCollection,
+		bson.M{"prefix": prefix},
+	).Decode(&key); err != nil {
+		authFailure(ctx, models.EventAPIKeyRejected, primitive.NilObjectID, "unknown key")
+		unauthorized(ctx, "Invalid api key", "invalid_token")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(rawKey)), []byte(key.Hash)) != 1 {
+		authFailure(ctx, models.EventAPIKeyRejected, key.User, "secret mismatch")
+		unauthorized(ctx, "Invalid api key", "invalid_token")
+		return
+	}
+
+	if key.ExpiresAt != nil && key.ExpiresAt.Time().Before(time.Now()) {
+		authFailure(ctx, models.EventAPIKeyRejected, key.User, "key expired")
+		unauthorized(ctx, "Api key has expired", "invalid_token")
+		return
+	}
+
+	if !key.HasScope(scope) {
+		authFailure(ctx, models.EventAPIKeyRejected, key.User, fmt.Sprintf("missing scope %v", scope))
+		ctx.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="insufficient_scope", scope="%s"`, realm, scope))
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": fmt.Sprintf("Api key is missing the %v scope", scope),
+		})
+		ctx.Abort()
+		return
+	}
+
+	options := options.FindOne().SetProjection(bson.M{"password": 0})
+	result := db.FindOne(context.Background(), models.UserCollection, bson.M{"_id": key.User}, options)
+
+	if err := result.Err(); err != nil {
+		authFailure(ctx, models.EventAPIKeyRejected, key.User, "user not found")
+		unauthorized(ctx, "Not authorized", "invalid_token")
+		return
+	}
+
+	var user models.User
+	result.Decode(&user)
+
+	if !user.Active() {
+		authFailure(ctx, models.EventAPIKeyRejected, user.Id, fmt.Sprintf("account %v", user.Status))
+		forbidden(ctx, fmt.Sprintf("Account is %v", user.Status))
+		return
+	}
+
+	if key.LastUsedAt == nil || time.Since(key.LastUsedAt.Time()) > time.Minute {
+		db.UpdateOne(
+			context.Background(),
+			models.APIKeyCollection,
+			bson.M{"_id": key.Id},
+			bson.M{
+				"$set": bson.M{"lastUsedAt": primitive.NewDateTimeFromTime(time.Now())},
+			})
+	}
+
+	ctx.Set("user", user)
+	ctx.Set("apiKey", key)
+	ctx.Set("tokenSource", APIKeySource)
+	ctx.Next()
+}
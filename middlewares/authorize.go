@@ -2,7 +2,8 @@ package middlewares
 
 import (
 	"context"
-	"net/http"
+	"fmt"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/saheemshafi/gin-basic-api/db"
@@ -14,42 +15,121 @@ import (
 )
 
 func Authorize(ctx *gin.Context) {
-	cookie, err := ctx.Cookie("token")
+	if key := ctx.GetHeader(apiKeyHeader); key != "" {
+		authorizeAPIKey(ctx, key)
+		return
+	}
 
-	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"message": "You are not logged in",
-		})
-		ctx.Abort()
+	tokenString, source := extractToken(ctx)
+
+	if tokenString == "" {
+		unauthorized(ctx, "You are not logged in", "")
 		return
 	}
 
-	token, err := utils.DecodeJWT(cookie)
+	token, err := utils.DecodeJWT(tokenString)
 
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"message": err.Error(),
+		authFailure(ctx, models.EventTokenRejected, primitive.NilObjectID, err.Error())
+		unauthorized(ctx, err.Error(), "invalid_token")
+		return
+	}
+
+	subject, _ := token["sub"].(string)
+	tokenId, _ := token["jti"].(string)
+
+	userId, _ := primitive.ObjectIDFromHex(subject)
+	sessionId, _ := primitive.ObjectIDFromHex(tokenId)
+
+	sessionResult := db.FindOne(
+		context.Background(),
+		models.SessionCollection,
+		bson.M{
+			"_id":     sessionId,
+			"user":    userId,
+			"revoked": false,
 		})
-		ctx.Abort()
+
+	if err := sessionResult.Err(); err != nil {
+		authFailure(ctx, models.EventTokenRejected, userId, "session revoked")
+		unauthorized(ctx, "Session has been revoked", "invalid_token")
 		return
 	}
 
-	userId, _ := primitive.ObjectIDFromHex(token["jti"].(string))
+	var session models.Session
+	sessionResult.Decode(&session)
+
+	if time.Since(session.LastSeenAt.Time()) > time.Minute {
+		db.UpdateOne(
+			context.Background(),
+			models.SessionCollection,
+			bson.M{"_id": sessionId},
+			bson.M{
+				"$set": bson.M{
+					"lastSeenAt": primitive.NewDateTimeFromTime(time.Now()),
+					"ip":         ctx.ClientIP(),
+				},
+			})
+	}
 
 	options := options.FindOne().SetProjection(bson.M{"password": 0})
 	result := db.Db.Collection("users").FindOne(context.Background(), bson.M{"_id": userId}, options)
 
 	if err := result.Err(); err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Not authorized",
-		})
-		ctx.Abort()
+		authFailure(ctx, models.EventTokenRejected, userId, "user not found")
+		unauthorized(ctx, "Not authorized", "invalid_token")
 		return
 	}
 
 	var user models.User
 	result.Decode(&user)
 
+	if !user.Active() {
+		authFailure(ctx, models.EventTokenRejected, user.Id, fmt.Sprintf("account %v", user.Status))
+		forbidden(ctx, fmt.Sprintf("Account is %v", user.Status))
+		return
+	}
+
+	// Tokens signed before the last password change carry an older version
+	version, _ := token["ver"].(float64)
+
+	if int(version) < user.TokenVersion {
+		authFailure(ctx, models.EventTokenRejected, user.Id, "token version outdated")
+		unauthorized(ctx, "Token is no longer valid", "invalid_token")
+		return
+	}
+
+	if source == CookieSource && !ValidCSRF(ctx, session) {
+
+		if session.CSRFToken == "" {
+			forbidden(ctx, "Session has no CSRF token, refresh it to get one")
+			return
+		}
+
+		forbidden(ctx, "Invalid CSRF token")
+		return
+	}
+
 	ctx.Set("user", user)
-	ctx.Next()
+	ctx.Set("session", session)
+	ctx.Set("tokenSource", source)
+
+	actor, _ := token["act"].(map[string]any)
+	actorId, _ := actor["sub"].(string)
+
+	if actorId == "" && session.Impersonator == nil {
+		ctx.Next()
+		return
+	}
+
+	admin, ok := findImpersonator(session, actorId)
+
+	if !ok {
+		authFailure(ctx, models.EventTokenRejected, user.Id, "impersonation not allowed")
+		unauthorized(ctx, "Impersonation is no longer valid", "invalid_token")
+		return
+	}
+
+	ctx.Set("impersonator", admin)
+	auditImpersonation(ctx, admin, user.Id)
 }
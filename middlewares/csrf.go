@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+)
+
+const CSRFHeader = "X-CSRF-Token"
+
+/*
+Cookies are sent by the browser no matter which site made the request, so
+state changing requests authenticated by cookie also have to echo the
+session's CSRF token in a header. The token is handed out in a readable
+cookie and the login response, only its hash is kept on the session.
+Bearer tokens and api keys can't be attached by another site and skip
+this.
+*/
+func ValidCSRF(ctx *gin.Context, session models.Session) bool {
+	switch ctx.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	header := ctx.GetHeader(CSRFHeader)
+
+	if header == "" || session.CSRFToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(utils.HashToken(header)), []byte(session.CSRFToken)) == 1
+}
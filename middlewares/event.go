@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+Appends an auth event for the request. Failing to write it is logged but
+never fails the request itself.
+*/
+func RecordAuthEvent(ctx *gin.Context, event models.AuthEvent) {
+	event.IP = ctx.ClientIP()
+	event.UserAgent = ctx.Request.UserAgent()
+
+	if _, err := event.Insert(); err != nil {
+		log.Println("Failed to write auth event:", err)
+	}
+}
+
+func authFailure(ctx *gin.Context, eventType models.AuthEventType, userId primitive.ObjectID, reason string) {
+	RecordAuthEvent(ctx, models.AuthEvent{
+		User:    userId,
+		Type:    eventType,
+		Outcome: models.OutcomeFailure,
+		Reason:  reason,
+	})
+}
@@ -0,0 +1,76 @@
+package middlewares
+
+import (
+	"context"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Must be chained after Authorize. Keeps admins impersonating a user away
+from actions that would take over the account.
+*/
+func BlockImpersonation(ctx *gin.Context) {
+	if _, impersonating := ctx.Get("impersonator"); impersonating {
+		forbidden(ctx, "This action isn't available while impersonating")
+		return
+	}
+
+	ctx.Next()
+}
+
+/*
+Loads the admin behind an impersonation session. The token's "act" claim
+has to name the same admin the session was opened by, and that admin must
+still be allowed to manage users.
+*/
+func findImpersonator(session models.Session, actor string) (models.User, bool) {
+	var admin models.User
+
+	if session.Impersonator == nil || session.Impersonator.Hex() != actor {
+		return admin, false
+	}
+
+	options := options.FindOne().SetProjection(bson.M{"password": 0})
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": *session.Impersonator},
+		options,
+	).Decode(&admin); err != nil {
+		return admin, false
+	}
+
+	return admin, admin.Active() && admin.Role.Can(models.PermissionManageUsers)
+}
+
+/*
+Runs the rest of the chain and writes an audit log entry for the request
+made on the user's behalf
+*/
+func auditImpersonation(ctx *gin.Context, admin models.User, userId primitive.ObjectID) {
+	ctx.Next()
+
+	entry := models.AuditLog{
+		Actor:  admin.Id,
+		Action: "user.impersonated_request",
+		Target: userId,
+		Details: map[string]any{
+			"method": ctx.Request.Method,
+			"path":   ctx.Request.URL.Path,
+			"status": ctx.Writer.Status(),
+		},
+		IP: ctx.ClientIP(),
+	}
+
+	if _, err := entry.Insert(); err != nil {
+		log.Println("Failed to write audit log:", err)
+	}
+}
@@ -0,0 +1,59 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/models"
+)
+
+/*
+Must be chained after Authorize. Lets the request through only when the
+user has one of the given roles.
+*/
+func RequireRole(roles ...models.Role) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userFromCtx, _ := ctx.Get("user")
+		user := userFromCtx.(models.User)
+
+		role := user.Role
+
+		if role == "" {
+			role = models.DefaultRole
+		}
+
+		for _, allowed := range roles {
+			if role == allowed {
+				ctx.Next()
+				return
+			}
+		}
+
+		forbidden(ctx, "You don't have permission to do this")
+	}
+}
+
+/*
+Must be chained after Authorize. Lets the request through only when the
+user's role grants the permission.
+*/
+func RequirePermission(permission models.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userFromCtx, _ := ctx.Get("user")
+		user := userFromCtx.(models.User)
+
+		if !user.Role.Can(permission) {
+			forbidden(ctx, "You don't have permission to do this")
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+func forbidden(ctx *gin.Context, message string) {
+	ctx.JSON(http.StatusForbidden, gin.H{
+		"message": message,
+	})
+	ctx.Abort()
+}
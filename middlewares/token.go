@@ -0,0 +1,84 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/utils"
+)
+
+const (
+	CookieSource = "cookie"
+	BearerSource = "bearer"
+)
+
+const realm = "gin-basic-api"
+
+/*
+Order in which Authorize looks for a token, read from AUTH_TOKEN_SOURCES
+as a comma separated list e.g. "bearer,cookie". Defaults to cookie first.
+*/
+func tokenSources() []string {
+	value := os.Getenv("AUTH_TOKEN_SOURCES")
+
+	if strings.TrimSpace(value) == "" {
+		return []string{CookieSource, BearerSource}
+	}
+
+	var sources []string
+
+	for _, source := range strings.Split(value, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+
+		if source == CookieSource || source == BearerSource {
+			sources = append(sources, source)
+		}
+	}
+
+	return sources
+}
+
+/*
+Returns the first token found in the configured sources along with the
+source it came from
+*/
+func extractToken(ctx *gin.Context) (string, string) {
+	for _, source := range tokenSources() {
+		switch source {
+		case CookieSource:
+			if cookie, err := ctx.Cookie(utils.GetCookieConfig().SessionName); err == nil && cookie != "" {
+				return cookie, source
+			}
+		case BearerSource:
+			header := ctx.GetHeader("Authorization")
+			scheme, token, found := strings.Cut(header, " ")
+
+			if found && strings.EqualFold(scheme, "Bearer") && strings.TrimSpace(token) != "" {
+				return strings.TrimSpace(token), source
+			}
+		}
+	}
+
+	return "", ""
+}
+
+/*
+Aborts with 401 and a RFC 6750 challenge. errorCode is left empty when the
+request carried no credentials at all.
+*/
+func unauthorized(ctx *gin.Context, message string, errorCode string) {
+	challenge := fmt.Sprintf(`Bearer realm="%s"`, realm)
+
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`, error="%s", error_description="%s"`, errorCode, message)
+	}
+
+	ctx.Header("WWW-Authenticate", challenge)
+	ctx.JSON(http.StatusUnauthorized, gin.H{
+		"message": message,
+	})
+	ctx.Abort()
+}
@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/models"
+)
+
+/*
+Must be chained after Authorize. Blocks users with an unverified email
+when REQUIRE_EMAIL_VERIFICATION is set to "books".
+*/
+func RequireVerifiedEmail(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "books" && !user.EmailVerified {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "Verify your email first",
+		})
+		ctx.Abort()
+		return
+	}
+
+	ctx.Next()
+}
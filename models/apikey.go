@@ -0,0 +1,68 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const APIKeyCollection = "api_keys"
+
+type Scope string
+
+const (
+	ScopeBooksRead  Scope = "books:read"
+	ScopeBooksWrite Scope = "books:write"
+	ScopePagesWrite Scope = "pages:write"
+)
+
+var Scopes = []Scope{ScopeBooksRead, ScopeBooksWrite, ScopePagesWrite}
+
+func (scope Scope) Valid() bool {
+	for _, known := range Scopes {
+		if scope == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Key for machine clients acting as a user. The full key is only shown once,
+Prefix is kept in the clear to find the key and Hash to verify it.
+*/
+type APIKey struct {
+	Id         primitive.ObjectID  `json:"_id" bson:"_id"`
+	User       primitive.ObjectID  `json:"user" bson:"user"`
+	Name       string              `json:"name" bson:"name"`
+	Prefix     string              `json:"prefix" bson:"prefix"`
+	Hash       string              `json:"-" bson:"hash"`
+	Scopes     []Scope             `json:"scopes" bson:"scopes"`
+	ExpiresAt  *primitive.DateTime `json:"expiresAt" bson:"expiresAt,omitempty"`
+	LastUsedAt *primitive.DateTime `json:"lastUsedAt" bson:"lastUsedAt,omitempty"`
+	CreatedAt  primitive.DateTime  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  primitive.DateTime  `json:"updatedAt" bson:"updatedAt"`
+}
+
+func (key *APIKey) Insert() (*mongo.InsertOneResult, error) {
+
+	key.Id = primitive.NewObjectID()
+	key.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	key.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	return db.InsertOne(context.Background(), APIKeyCollection, key)
+}
+
+func (key APIKey) HasScope(scope Scope) bool {
+	for _, granted := range key.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
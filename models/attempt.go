@@ -0,0 +1,18 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const LoginAttemptCollection = "login_attempts"
+
+/*
+Failed login counter for one key, either an account ("email:<address>")
+or a client ("ip:<address>")
+*/
+type LoginAttempt struct {
+	Id            primitive.ObjectID  `json:"_id" bson:"_id"`
+	Key           string              `json:"key" bson:"key"`
+	Failures      int                 `json:"failures" bson:"failures"`
+	NextAttemptAt primitive.DateTime  `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LockedUntil   *primitive.DateTime `json:"lockedUntil" bson:"lockedUntil,omitempty"`
+	UpdatedAt     primitive.DateTime  `json:"updatedAt" bson:"updatedAt"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const AuditLogCollection = "audit_logs"
+
+/*
+Record of an administrative action taken by Actor on the Target user
+*/
+type AuditLog struct {
+	Id        primitive.ObjectID `json:"_id" bson:"_id"`
+	Actor     primitive.ObjectID `json:"actor" bson:"actor"`
+	Action    string             `json:"action" bson:"action"`
+	Target    primitive.ObjectID `json:"target" bson:"target"`
+	Details   map[string]any     `json:"details,omitempty" bson:"details,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	CreatedAt primitive.DateTime `json:"createdAt" bson:"createdAt"`
+}
+
+func (entry *AuditLog) Insert() (*mongo.InsertOneResult, error) {
+
+	entry.Id = primitive.NewObjectID()
+	entry.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	return db.InsertOne(context.Background(), AuditLogCollection, entry)
+}
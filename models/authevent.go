@@ -0,0 +1,71 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const AuthEventCollection = "auth_events"
+
+type AuthEventType string
+
+const (
+	EventLogin            AuthEventType = "login"
+	EventLoginTwoFactor   AuthEventType = "login_2fa"
+	EventOIDCLogin        AuthEventType = "oidc_login"
+	EventMagicLinkLogin   AuthEventType = "magic_link_login"
+	EventPasskeyLogin     AuthEventType = "passkey_login"
+	EventRefresh          AuthEventType = "refresh"
+	EventLogout           AuthEventType = "logout"
+	EventTokenRejected    AuthEventType = "token_rejected"
+	EventAPIKeyRejected   AuthEventType = "api_key_rejected"
+	EventPasswordChange   AuthEventType = "password_change"
+	EventPasswordReset    AuthEventType = "password_reset"
+	EventEmailChange      AuthEventType = "email_change"
+	EventTwoFactorEnable  AuthEventType = "2fa_enabled"
+	EventTwoFactorDisable AuthEventType = "2fa_disabled"
+	EventSessionRevoked   AuthEventType = "session_revoked"
+	EventAPIKeyCreated    AuthEventType = "api_key_created"
+	EventAPIKeyDeleted    AuthEventType = "api_key_deleted"
+	EventPasskeyAdded     AuthEventType = "passkey_added"
+	EventPasskeyRemoved   AuthEventType = "passkey_removed"
+	EventAccountDeleted   AuthEventType = "account_deleted"
+)
+
+type AuthOutcome string
+
+const (
+	OutcomeSuccess AuthOutcome = "success"
+	OutcomeFailure AuthOutcome = "failure"
+)
+
+/*
+Authentication related event. Entries are never updated and outlive the
+account they belong to, so they stay trustworthy after an account is
+compromised. They are only removed by the TTL index once they are older
+than AUTH_EVENT_RETENTION_DAYS. User is empty when the attempt couldn't be
+tied to an account, Email then holds what was tried.
+*/
+type AuthEvent struct {
+	Id        primitive.ObjectID `json:"_id" bson:"_id"`
+	User      primitive.ObjectID `json:"user,omitempty" bson:"user,omitempty"`
+	Email     string             `json:"email,omitempty" bson:"email,omitempty"`
+	Type      AuthEventType      `json:"type" bson:"type"`
+	Outcome   AuthOutcome        `json:"outcome" bson:"outcome"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"userAgent" bson:"userAgent"`
+	CreatedAt primitive.DateTime `json:"createdAt" bson:"createdAt"`
+}
+
+func (event *AuthEvent) Insert() (*mongo.InsertOneResult, error) {
+
+	event.Id = primitive.NewObjectID()
+	event.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	return db.InsertOne(context.Background(), AuthEventCollection, event)
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Promotes the account registered with ADMIN_EMAIL to admin while there is
+no admin yet, so a fresh deployment can reach the admin api. The email has
+to be verified, otherwise whoever signed up with the address first would
+get the role. Once an admin exists roles are only changed through the api.
+*/
+func BootstrapAdmin(connectionCh chan<- string) error {
+	email := NormalizeEmail(os.Getenv("ADMIN_EMAIL"))
+
+	if email == "" {
+		return nil
+	}
+
+	admins, err := db.Count(context.Background(), UserCollection, bson.M{"role": RoleAdmin})
+
+	if err != nil {
+		return err
+	}
+
+	if admins > 0 {
+		return nil
+	}
+
+	var user User
+
+	if err := db.UpdateOne(
+		context.Background(),
+		UserCollection,
+		bson.M{"email": email, "emailVerified": true},
+		bson.M{
+			"$set": bson.M{
+				"role":      RoleAdmin,
+				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	).Decode(&user); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			connectionCh <- fmt.Sprintf("No verified account for ADMIN_EMAIL %v yet, register and verify it then restart", email)
+			return nil
+		}
+
+		return err
+	}
+
+	connectionCh <- fmt.Sprintf("Promoted %v to admin", email)
+	return nil
+}
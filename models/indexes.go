@@ -0,0 +1,170 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Unique indexes the handlers rely on to stay correct under concurrent
+requests, plus the ones backing frequent listings
+*/
+var indexes = map[string][]mongo.IndexModel{
+	UserCollection: {
+		{Keys: bson.M{"email": 1}, Options: options.Index().SetUnique(true)},
+	},
+	APIKeyCollection: {
+		{Keys: bson.M{"prefix": 1}, Options: options.Index().SetUnique(true)},
+	},
+	LoginAttemptCollection: {
+		{Keys: bson.M{"key": 1}, Options: options.Index().SetUnique(true)},
+	},
+	InviteCollection: {
+		{Keys: bson.M{"hash": 1}, Options: options.Index().SetUnique(true)},
+	},
+	PasskeyCollection: {
+		{Keys: bson.M{"credentialId": 1}, Options: options.Index().SetUnique(true)},
+	},
+	SessionCollection: {
+		{Keys: bson.M{"refreshToken": 1}},
+		{Keys: bson.M{"rotatedTokens": 1}},
+	},
+	TokenCollection: {
+		{Keys: bson.M{"hash": 1}, Options: options.Index().SetUnique(true)},
+	},
+	OIDCStateCollection: {
+		{Keys: bson.M{"stateHash": 1}, Options: options.Index().SetUnique(true)},
+	},
+	AuthEventCollection: {
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "createdAt", Value: -1}}},
+	},
+}
+
+/*
+How long auth events are kept, read from AUTH_EVENT_RETENTION_DAYS.
+Failures can be produced by anyone so the collection needs an upper bound.
+*/
+func authEventRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("AUTH_EVENT_RETENTION_DAYS"))
+
+	if err != nil || days <= 0 {
+		days = 90
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func EnsureIndexes(connectionCh chan<- string) error {
+	connectionCh <- "Ensuring database indexes..."
+
+	if err := normalizeEmails(); err != nil {
+		return err
+	}
+
+	for collection, models := range indexes {
+		if _, err := db.Db.Collection(collection).Indexes().CreateMany(context.Background(), models); err != nil {
+			return fmt.Errorf("failed to create indexes on %v: %w", collection, err)
+		}
+	}
+
+	if err := ensureTTLIndex(AuthEventCollection, "createdAt", authEventRetention()); err != nil {
+		return err
+	}
+
+	// Expired tokens and login states are never accepted again, so they go
+	// once they expire
+	for _, collection := range []string{TokenCollection, OIDCStateCollection} {
+		if err := ensureTTLIndex(collection, "expiresAt", 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Creates a TTL index on field, or updates its expiry when the index already
+exists with another retention
+*/
+func ensureTTLIndex(collection string, field string, ttl time.Duration) error {
+	seconds := int32(ttl.Seconds())
+
+	_, err := db.Db.Collection(collection).Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{field: 1},
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+
+	var commandErr mongo.CommandError
+
+	// IndexOptionsConflict
+	if !errors.As(err, &commandErr) || commandErr.Code != 85 {
+		return err
+	}
+
+	return db.Db.RunCommand(context.Background(), bson.D{
+		{Key: "collMod", Value: collection},
+		{Key: "index", Value: bson.M{
+			"keyPattern":         bson.M{field: 1},
+			"expireAfterSeconds": seconds,
+		}},
+	}).Err()
+}
+
+/*
+Lowercases emails stored before they were normalised on write. Accounts
+whose emails only differ in case can't be merged automatically, so startup
+stops and lists them until they are merged or one of them gets another
+address.
+*/
+func normalizeEmails() error {
+	normalized := bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}
+
+	cursor, err := db.Db.Collection(UserCollection).Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": normalized, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var duplicates []struct {
+		Email string `bson:"_id"`
+	}
+
+	if err := cursor.All(context.Background(), &duplicates); err != nil {
+		return err
+	}
+
+	if len(duplicates) > 0 {
+		emails := make([]string, 0, len(duplicates))
+
+		for _, duplicate := range duplicates {
+			emails = append(emails, duplicate.Email)
+		}
+
+		return fmt.Errorf(
+			"users share these emails when case is ignored: %v. Merge the accounts or change their emails so each is unique, then restart",
+			strings.Join(emails, ", "),
+		)
+	}
+
+	_, err = db.UpdateMany(
+		context.Background(),
+		UserCollection,
+		bson.M{"$expr": bson.M{"$ne": bson.A{"$email", normalized}}},
+		bson.A{bson.M{"$set": bson.M{"email": normalized}}},
+	)
+
+	return err
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const InviteCollection = "invites"
+
+/*
+Invitation to register. Only the hash of the code is stored, the code is
+shown once when the invite is created. UsedBy lists the accounts that
+signed up with it.
+*/
+type Invite struct {
+	Id        primitive.ObjectID   `json:"_id" bson:"_id"`
+	CreatedBy primitive.ObjectID   `json:"createdBy" bson:"createdBy"`
+	Hash      string               `json:"-" bson:"hash"`
+	Note      string               `json:"note,omitempty" bson:"note,omitempty"`
+	MaxUses   int                  `json:"maxUses" bson:"maxUses"`
+	Uses      int                  `json:"uses" bson:"uses"`
+	UsedBy    []primitive.ObjectID `json:"usedBy" bson:"usedBy"`
+	Revoked   bool                 `json:"revoked" bson:"revoked"`
+	ExpiresAt *primitive.DateTime  `json:"expiresAt" bson:"expiresAt,omitempty"`
+	CreatedAt primitive.DateTime   `json:"createdAt" bson:"createdAt"`
+}
+
+func (invite *Invite) Insert() (*mongo.InsertOneResult, error) {
+
+	invite.Id = primitive.NewObjectID()
+	invite.UsedBy = []primitive.ObjectID{}
+	invite.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	return db.InsertOne(context.Background(), InviteCollection, invite)
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const JobCollection = "jobs"
+
+type JobType string
+
+const (
+	JobAccountDeletion JobType = "account-deletion"
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+/*
+Background work persisted so it can be followed and resumed after a restart
+*/
+type Job struct {
+	Id        primitive.ObjectID `json:"_id" bson:"_id"`
+	Type      JobType            `json:"type" bson:"type"`
+	User      primitive.ObjectID `json:"user" bson:"user"`
+	Assets    []string           `json:"-" bson:"assets,omitempty"`
+	Status    JobStatus          `json:"status" bson:"status"`
+	Attempts  int                `json:"attempts" bson:"attempts"`
+	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt primitive.DateTime `json:"createdAt" bson:"createdAt"`
+	UpdatedAt primitive.DateTime `json:"updatedAt" bson:"updatedAt"`
+}
+
+func (job *Job) Insert() (*mongo.InsertOneResult, error) {
+
+	job.Id = primitive.NewObjectID()
+	job.Status = JobPending
+	job.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	job.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	return db.InsertOne(context.Background(), JobCollection, job)
+}
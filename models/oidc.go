@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const OIDCStateCollection = "oidc_states"
+
+const OIDCStateTTL = 10 * time.Minute
+
+/*
+Account at an external OpenID Connect provider linked to a user
+*/
+type Identity struct {
+	Provider string             `json:"provider" bson:"provider"`
+	Subject  string             `json:"-" bson:"subject"`
+	Email    string             `json:"email" bson:"email"`
+	LinkedAt primitive.DateTime `json:"linkedAt" bson:"linkedAt"`
+}
+
+/*
+Pending authorization request, looked up by the hash of the state parameter
+when the provider redirects back
+*/
+type OIDCState struct {
+	Id           primitive.ObjectID `json:"_id" bson:"_id"`
+	Provider     string             `json:"provider" bson:"provider"`
+	StateHash    string             `json:"-" bson:"stateHash"`
+	Nonce        string             `json:"-" bson:"nonce"`
+	CodeVerifier string             `json:"-" bson:"codeVerifier"`
+	InviteCode   string             `json:"-" bson:"inviteCode,omitempty"`
+	ExpiresAt    primitive.DateTime `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt    primitive.DateTime `json:"createdAt" bson:"createdAt"`
+}
+
+func (state *OIDCState) Insert() (*mongo.InsertOneResult, error) {
+
+	state.Id = primitive.NewObjectID()
+	state.ExpiresAt = primitive.NewDateTimeFromTime(time.Now().Add(OIDCStateTTL))
+	state.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	return db.InsertOne(context.Background(), OIDCStateCollection, state)
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const PasskeyCollection = "passkeys"
+
+/*
+WebAuthn credential registered by a user. CredentialId is the base64url
+credential id and PublicKey the COSE encoded key. SignCount is the last
+counter the authenticator reported, used to spot cloned authenticators.
+*/
+type Passkey struct {
+	Id           primitive.ObjectID  `json:"_id" bson:"_id"`
+	User         primitive.ObjectID  `json:"user" bson:"user"`
+	Name         string              `json:"name" bson:"name"`
+	CredentialId string              `json:"credentialId" bson:"credentialId"`
+	PublicKey    []byte              `json:"-" bson:"publicKey"`
+	Algorithm    int                 `json:"algorithm" bson:"algorithm"`
+	SignCount    uint32              `json:"-" bson:"signCount"`
+	Transports   []string            `json:"transports" bson:"transports"`
+	BackedUp     bool                `json:"backedUp" bson:"backedUp"`
+	LastUsedAt   *primitive.DateTime `json:"lastUsedAt" bson:"lastUsedAt,omitempty"`
+	CreatedAt    primitive.DateTime  `json:"createdAt" bson:"createdAt"`
+}
+
+func (passkey *Passkey) Insert() (*mongo.InsertOneResult, error) {
+
+	passkey.Id = primitive.NewObjectID()
+	passkey.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	if passkey.Transports == nil {
+		passkey.Transports = []string{}
+	}
+
+	return db.InsertOne(context.Background(), PasskeyCollection, passkey)
+}
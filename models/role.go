@@ -0,0 +1,76 @@
+package models
+
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleAuthor Role = "author"
+	RoleReader Role = "reader"
+)
+
+/*
+Role given to new accounts and to accounts created before roles existed
+*/
+const DefaultRole = RoleAuthor
+
+type Permission string
+
+const (
+	PermissionCreateBooks   Permission = "books:create"
+	PermissionEditOwnBooks  Permission = "books:edit:own"
+	PermissionEditAnyBook   Permission = "books:edit:any"
+	PermissionDeleteAnyBook Permission = "books:delete:any"
+	PermissionManageUsers   Permission = "users:manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermissionCreateBooks,
+		PermissionEditOwnBooks,
+		PermissionEditAnyBook,
+		PermissionDeleteAnyBook,
+		PermissionManageUsers,
+	},
+	RoleEditor: {
+		PermissionCreateBooks,
+		PermissionEditOwnBooks,
+		PermissionEditAnyBook,
+	},
+	RoleAuthor: {
+		PermissionCreateBooks,
+		PermissionEditOwnBooks,
+	},
+	RoleReader: {},
+}
+
+func (role Role) Valid() bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func (role Role) Can(permission Permission) bool {
+	if role == "" {
+		role = DefaultRole
+	}
+
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Single ownership policy for books and their pages. Authors can modify what
+they wrote, anyone holding anyPermission can modify every book.
+*/
+func CanModifyBook(user User, book Book, anyPermission Permission) bool {
+	if user.Role.Can(anyPermission) {
+		return true
+	}
+
+	return book.Author == user.Id && user.Role.Can(PermissionEditOwnBooks)
+}
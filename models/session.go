@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const SessionCollection = "sessions"
+
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+/*
+A session is one signed in device and its refresh token family. RefreshToken holds the hash of the
+currently valid token and RotatedTokens the hashes of the ones it replaced,
+so presenting any of those again means the family was leaked. CSRFToken
+holds the hash of the token cookie authenticated requests have to echo.
+LoginMethod is how the session was signed in, recent passwordless logins
+count as re-authentication. Impersonator is only set on sessions an admin
+opened as the user.
+*/
+type Session struct {
+	Id            primitive.ObjectID  `json:"_id" bson:"_id"`
+	User          primitive.ObjectID  `json:"user" bson:"user"`
+	RefreshToken  string              `json:"-" bson:"refreshToken"`
+	CSRFToken     string              `json:"-" bson:"csrfToken"`
+	RotatedTokens []string            `json:"-" bson:"rotatedTokens"`
+	UserAgent     string              `json:"userAgent" bson:"userAgent"`
+	IP            string              `json:"ip" bson:"ip"`
+	TokenVersion  int                 `json:"-" bson:"tokenVersion"`
+	LoginMethod   AuthEventType       `json:"loginMethod,omitempty" bson:"loginMethod,omitempty"`
+	Impersonator  *primitive.ObjectID `json:"impersonator,omitempty" bson:"impersonator,omitempty"`
+	Revoked       bool                `json:"revoked" bson:"revoked"`
+	ExpiresAt     primitive.DateTime  `json:"expiresAt" bson:"expiresAt"`
+	LastSeenAt    primitive.DateTime  `json:"lastSeenAt" bson:"lastSeenAt"`
+	CreatedAt     primitive.DateTime  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     primitive.DateTime  `json:"updatedAt" bson:"updatedAt"`
+}
+
+func (session *Session) Insert() (*mongo.InsertOneResult, error) {
+
+	session.Id = primitive.NewObjectID()
+	session.RotatedTokens = []string{}
+
+	if session.ExpiresAt == 0 {
+		session.ExpiresAt = primitive.NewDateTimeFromTime(time.Now().Add(RefreshTokenTTL))
+	}
+
+	session.LastSeenAt = primitive.NewDateTimeFromTime(time.Now())
+	session.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	session.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	return db.InsertOne(context.Background(), SessionCollection, session)
+}
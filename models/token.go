@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const TokenCollection = "tokens"
+
+type TokenPurpose string
+
+const (
+	TokenPasswordReset     TokenPurpose = "password-reset"
+	TokenEmailVerification TokenPurpose = "email-verification"
+	TokenLoginChallenge    TokenPurpose = "login-challenge"
+	TokenEmailChange       TokenPurpose = "email-change"
+	TokenMagicLink         TokenPurpose = "magic-link"
+	TokenPasskeyCreation   TokenPurpose = "passkey-creation"
+	TokenPasskeyAssertion  TokenPurpose = "passkey-assertion"
+)
+
+/*
+Single use token sent to the user out of band. Only the hash of the token
+is stored. Email change tokens also carry the address being confirmed and
+magic links the hash of the nonce cookie of the browser that asked for it.
+*/
+type Token struct {
+	Id        primitive.ObjectID `json:"_id" bson:"_id"`
+	User      primitive.ObjectID `json:"user" bson:"user"`
+	Purpose   TokenPurpose       `json:"purpose" bson:"purpose"`
+	Hash      string             `json:"-" bson:"hash"`
+	Email     string             `json:"-" bson:"email,omitempty"`
+	Nonce     string             `json:"-" bson:"nonce,omitempty"`
+	Used      bool               `json:"used" bson:"used"`
+	Attempts  int                `json:"attempts" bson:"attempts"`
+	ExpiresAt primitive.DateTime `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt primitive.DateTime `json:"createdAt" bson:"createdAt"`
+}
+
+func (token *Token) Insert() (*mongo.InsertOneResult, error) {
+
+	token.Id = primitive.NewObjectID()
+	token.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	return db.InsertOne(context.Background(), TokenCollection, token)
+}
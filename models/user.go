@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/saheemshafi/gin-basic-api/db"
@@ -12,13 +13,101 @@ import (
 
 const UserCollection = "users"
 
+type UserStatus string
+
+const (
+	UserActive    UserStatus = "active"
+	UserSuspended UserStatus = "suspended"
+	UserBanned    UserStatus = "banned"
+)
+
 type User struct {
-	Id        primitive.ObjectID `json:"_id" bson:"_id"`
-	Name      string             `json:"name" bson:"name" binding:"required"`
-	Email     string             `json:"email" bson:"email" binding:"required,email"`
-	Password  string             `json:"password,omitempty" bson:"password" binding:"required"`
-	CreatedAt primitive.DateTime `json:"createdAt" bson:"createdAt"`
-	UpdatedAt primitive.DateTime `json:"updatedAt" bson:"updatedAt"`
+	Id                    primitive.ObjectID  `json:"_id" bson:"_id"`
+	Name                  string              `json:"name" bson:"name" binding:"required"`
+	Email                 string              `json:"email" bson:"email" binding:"required,email"`
+	Bio                   string              `json:"bio" bson:"bio"`
+	Avatar                string              `json:"avatar" bson:"avatar"`
+	Password              string              `json:"password,omitempty" bson:"password" binding:"required"`
+	Role                  Role                `json:"role" bson:"role"`
+	EmailVerified         bool                `json:"emailVerified" bson:"emailVerified"`
+	TokenVersion          int                 `json:"-" bson:"tokenVersion"`
+	TOTPEnabled           bool                `json:"twoFactorEnabled" bson:"totpEnabled"`
+	TOTPSecret            string              `json:"-" bson:"totpSecret"`
+	TOTPLastStep          int64               `json:"-" bson:"totpLastStep"`
+	RecoveryCodes         []string            `json:"-" bson:"recoveryCodes"`
+	PasskeyTwoFactor      bool                `json:"passkeyTwoFactor" bson:"passkeyTwoFactor"`
+	Identities            []Identity          `json:"identities" bson:"identities,omitempty"`
+	Status                UserStatus          `json:"status" bson:"status"`
+	StatusReason          string              `json:"statusReason,omitempty" bson:"statusReason,omitempty"`
+	SuspendedUntil        *primitive.DateTime `json:"suspendedUntil,omitempty" bson:"suspendedUntil,omitempty"`
+	PasswordResetRequired bool                `json:"passwordResetRequired" bson:"passwordResetRequired"`
+	Invite                *primitive.ObjectID `json:"invite,omitempty" bson:"invite,omitempty"`
+	CreatedAt             primitive.DateTime  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt             primitive.DateTime  `json:"updatedAt" bson:"updatedAt"`
+}
+
+/*
+Reports whether the account may sign in and use the api. Suspensions
+without an end date last until lifted by an admin.
+*/
+func (user User) Active() bool {
+	switch user.Status {
+	case UserBanned:
+		return false
+	case UserSuspended:
+		return user.SuspendedUntil != nil && user.SuspendedUntil.Time().Before(time.Now())
+	}
+
+	return true
+}
+
+/*
+Public view of a user, safe to show to anyone
+*/
+type Profile struct {
+	Id       primitive.ObjectID `json:"_id"`
+	Name     string             `json:"name"`
+	Bio      string             `json:"bio"`
+	Avatar   map[string]string  `json:"avatar"`
+	JoinedAt primitive.DateTime `json:"joinedAt"`
+}
+
+func (user User) Profile() Profile {
+	return Profile{
+		Id:       user.Id,
+		Name:     user.Name,
+		Bio:      user.Bio,
+		Avatar:   user.AvatarURLs(),
+		JoinedAt: user.CreatedAt,
+	}
+}
+
+/*
+Square sizes avatars are delivered in, in pixels
+*/
+var AvatarSizes = map[string]int{
+	"small":  64,
+	"medium": 128,
+	"large":  256,
+}
+
+/*
+Delivery urls for the avatar keyed by size name, nil without an avatar
+*/
+func (user User) AvatarURLs() map[string]string {
+	if user.Avatar == "" {
+		return nil
+	}
+
+	urls := map[string]string{}
+
+	for name, size := range AvatarSizes {
+		if url, err := utils.ImageURL(user.Avatar, size); err == nil {
+			urls[name] = url
+		}
+	}
+
+	return urls
 }
 
 func (user *User) Insert() (*mongo.InsertOneResult, error) {
@@ -30,9 +119,19 @@ func (user *User) Insert() (*mongo.InsertOneResult, error) {
 	}
 
 	user.Id = primitive.NewObjectID()
+	user.Email = NormalizeEmail(user.Email)
 	user.Password = hash
+	user.Status = UserActive
 	user.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	user.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
 
 	return db.InsertOne(context.Background(), UserCollection, user)
 }
+
+/*
+Emails are stored and looked up trimmed and lowercased so the unique index
+treats addresses that only differ in case as the same account
+*/
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,280 @@
+package routes
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func DeleteMe(ctx *gin.Context) {
+	var body reauthentication
+
+	// A recent passwordless login needs no body at all
+	if err := ctx.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := loadFullUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	if !reauthenticate(ctx, user, body, models.EventAccountDeleted) {
+		return
+	}
+
+	job := models.Job{
+		Type: models.JobAccountDeletion,
+		User: user.Id,
+	}
+
+	if user.Avatar != "" {
+		job.Assets = []string{user.Avatar}
+	}
+
+	if _, err := job.Insert(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+
+	// The account goes away right now, the content it owns is cleaned up by
+	// the job
+	if err := db.DeleteOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+	).Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+
+	if err := revokeAllSessions(user.Id); err != nil {
+		log.Println(err)
+	}
+
+	// Attempts are keyed by email, which the job doesn't know
+	if err := clearLoginFailures(accountAttemptKey(user.Email)); err != nil {
+		log.Println(err)
+	}
+
+	authEvent(ctx, models.EventAccountDeleted, user.Id, models.OutcomeSuccess, "")
+	startJob(job)
+
+	clearSessionCookies(ctx)
+	utils.WriteResponse(ctx, http.StatusAccepted, "Account deleted, your content is being removed", job)
+}
+
+/*
+Removes everything a deleted user owned. Covers are destroyed before the
+documents referencing them so a failed run can be retried.
+
+Auth events and admin audit logs are kept on purpose. They have to outlive
+an account taken over and deleted by an attacker, and auth events expire
+after AUTH_EVENT_RETENTION_DAYS anyway. OIDC login states aren't tied to an
+account and expire within minutes.
+*/
+func deleteAccountData(job models.Job) error {
+	cursor, err := db.Find(context.Background(), models.BookCollection, bson.M{"author": job.User})
+
+	if err != nil {
+		return err
+	}
+
+	var books []models.Book
+
+	if err := cursor.All(context.Background(), &books); err != nil {
+		return err
+	}
+
+	var bookIds, pageIds []primitive.ObjectID
+	covers := append([]string{}, job.Assets...)
+
+	for _, book := range books {
+		bookIds = append(bookIds, book.Id)
+		pageIds = append(pageIds, book.Pages...)
+
+		if book.Cover != "" {
+			covers = append(covers, book.Cover)
+		}
+	}
+
+	var pages []models.Page
+
+	if len(pageIds) > 0 {
+		cursor, err := db.Find(context.Background(), models.PageCollection, bson.M{"_id": bson.M{"$in": pageIds}})
+
+		if err != nil {
+			return err
+		}
+
+		if err := cursor.All(context.Background(), &pages); err != nil {
+			return err
+		}
+	}
+
+	for _, page := range pages {
+		if page.Cover != "" {
+			covers = append(covers, page.Cover)
+		}
+	}
+
+	for _, cover := range covers {
+		result, err := utils.DeleteFile(cover, api.Image)
+
+		if err != nil {
+			return err
+		}
+
+		if result.Error.Message != "" {
+			return fmt.Errorf("failed to delete %v: %v", cover, result.Error.Message)
+		}
+	}
+
+	if len(pageIds) > 0 {
+		if _, err := db.DeleteMany(context.Background(), models.PageCollection, bson.M{"_id": bson.M{"$in": pageIds}}); err != nil {
+			return err
+		}
+	}
+
+	if len(bookIds) > 0 {
+		if _, err := db.DeleteMany(context.Background(), models.BookCollection, bson.M{"_id": bson.M{"$in": bookIds}}); err != nil {
+			return err
+		}
+	}
+
+	for _, collection := range []string{
+		models.SessionCollection,
+		models.TokenCollection,
+		models.APIKeyCollection,
+		models.PasskeyCollection,
+	} {
+		if _, err := db.DeleteMany(context.Background(), collection, bson.M{"user": job.User}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.DeleteMany(context.Background(), models.InviteCollection, bson.M{"createdBy": job.User}); err != nil {
+		return err
+	}
+
+	_, err = db.UpdateMany(
+		context.Background(),
+		models.InviteCollection,
+		bson.M{"usedBy": job.User},
+		bson.M{"$pull": bson.M{"usedBy": job.User}},
+	)
+
+	return err
+}
+
+/*
+Streams a zip archive with everything the user owns as JSON files
+*/
+func ExportMe(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	files := map[string]any{
+		"user.json": user,
+	}
+
+	books := []models.Book{}
+	pages := []models.Page{}
+	sessions := []models.Session{}
+	apiKeys := []models.APIKey{}
+	passkeys := []models.Passkey{}
+
+	collections := []struct {
+		name       string
+		collection string
+		filter     bson.M
+		target     any
+	}{
+		{"books.json", models.BookCollection, bson.M{"author": user.Id}, &books},
+		{"sessions.json", models.SessionCollection, bson.M{"user": user.Id}, &sessions},
+		{"api-keys.json", models.APIKeyCollection, bson.M{"user": user.Id}, &apiKeys},
+		{"passkeys.json", models.PasskeyCollection, bson.M{"user": user.Id}, &passkeys},
+	}
+
+	for _, entry := range collections {
+		cursor, err := db.Find(context.Background(), entry.collection, entry.filter)
+
+		if err == nil {
+			err = cursor.All(context.Background(), entry.target)
+		}
+
+		if err != nil {
+			log.Println(err)
+			utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to export data")
+			return
+		}
+
+		files[entry.name] = entry.target
+	}
+
+	var pageIds []primitive.ObjectID
+
+	for _, book := range books {
+		pageIds = append(pageIds, book.Pages...)
+	}
+
+	if len(pageIds) > 0 {
+		cursor, err := db.Find(context.Background(), models.PageCollection, bson.M{"_id": bson.M{"$in": pageIds}})
+
+		if err == nil {
+			err = cursor.All(context.Background(), &pages)
+		}
+
+		if err != nil {
+			log.Println(err)
+			utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to export data")
+			return
+		}
+	}
+
+	files["pages.json"] = pages
+
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%v.zip"`, user.Id.Hex()))
+	ctx.Status(http.StatusOK)
+
+	archive := zip.NewWriter(ctx.Writer)
+
+	for name, content := range files {
+		file, err := archive.Create(name)
+
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(content); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Println(err)
+	}
+}
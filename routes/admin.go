@@ -0,0 +1,508 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const impersonationTTL = 15 * time.Minute
+
+/*
+Writes an audit log entry for an action the current admin took
+*/
+func recordAudit(ctx *gin.Context, action string, target primitive.ObjectID, details map[string]any) {
+	userFromCtx, _ := ctx.Get("user")
+	admin := userFromCtx.(models.User)
+
+	entry := models.AuditLog{
+		Actor:   admin.Id,
+		Action:  action,
+		Target:  target,
+		Details: details,
+		IP:      ctx.ClientIP(),
+	}
+
+	if _, err := entry.Insert(); err != nil {
+		log.Println("Failed to write audit log:", err)
+	}
+}
+
+/*
+Loads the user named by the :userId param, writing the error response and
+returning false when it can't
+*/
+func findTargetUser(ctx *gin.Context) (models.User, bool) {
+	var user models.User
+	userId, err := primitive.ObjectIDFromHex(ctx.Param("userId"))
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid user id")
+		return user, false
+	}
+
+	options := options.FindOne().SetProjection(bson.M{"password": 0})
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": userId},
+		options,
+	).Decode(&user); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusNotFound, "User not found")
+			return user, false
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return user, false
+	}
+
+	return user, true
+}
+
+/*
+Admins can't act on their own account so they can't lock themselves out
+*/
+func isSelf(ctx *gin.Context, target models.User) bool {
+	userFromCtx, _ := ctx.Get("user")
+
+	if userFromCtx.(models.User).Id == target.Id {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "You can't do this to your own account")
+		return true
+	}
+
+	return false
+}
+
+func ListUsers(ctx *gin.Context) {
+	filter := bson.M{}
+
+	if query := strings.TrimSpace(ctx.Query("q")); query != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"name": pattern},
+			bson.M{"email": pattern},
+		}
+	}
+
+	if role := ctx.Query("role"); role != "" {
+		filter["role"] = role
+	}
+
+	if status := ctx.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	page, limit, ok := paginate(ctx)
+
+	if !ok {
+		return
+	}
+
+	total, err := db.Count(context.Background(), models.UserCollection, filter)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+
+	options := options.Find().
+		SetProjection(bson.M{"password": 0}).
+		SetSort(bson.M{"createdAt": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := db.Find(context.Background(), models.UserCollection, filter, options)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+
+	users := []models.User{}
+
+	if err := cursor.All(context.Background(), &users); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Users retrieved", paginated(users, page, limit, total))
+}
+
+func GetUser(ctx *gin.Context) {
+	user, ok := findTargetUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "User retrieved", user)
+}
+
+func GetUserBooks(ctx *gin.Context) {
+	user, ok := findTargetUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	page, limit, ok := paginate(ctx)
+
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"author": user.Id}
+
+	total, err := db.Count(context.Background(), models.BookCollection, filter)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve books")
+		return
+	}
+
+	options := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := db.Find(context.Background(), models.BookCollection, filter, options)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve books")
+		return
+	}
+
+	books := []models.Book{}
+
+	if err := cursor.All(context.Background(), &books); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve books")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Books retrieved", paginated(books, page, limit, total))
+}
+
+/*
+Changes the user's status and signs them out everywhere when they lose
+access
+*/
+func setUserStatus(ctx *gin.Context, status models.UserStatus) {
+	var body struct {
+		Reason       string `json:"reason"`
+		DurationDays int    `json:"durationDays" binding:"min=0"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil && status != models.UserActive {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := findTargetUser(ctx)
+
+	if !ok || isSelf(ctx, user) {
+		return
+	}
+
+	changes := bson.M{
+		"$set": bson.M{
+			"status":    status,
+			"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+		},
+	}
+
+	switch status {
+	case models.UserActive:
+		changes["$unset"] = bson.M{"statusReason": "", "suspendedUntil": ""}
+	case models.UserSuspended:
+		changes["$set"].(bson.M)["statusReason"] = body.Reason
+
+		if body.DurationDays > 0 {
+			changes["$set"].(bson.M)["suspendedUntil"] = primitive.NewDateTimeFromTime(time.Now().AddDate(0, 0, body.DurationDays))
+		} else {
+			changes["$unset"] = bson.M{"suspendedUntil": ""}
+		}
+	case models.UserBanned:
+		changes["$set"].(bson.M)["statusReason"] = body.Reason
+		changes["$unset"] = bson.M{"suspendedUntil": ""}
+	}
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		changes,
+	).Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+
+	if status != models.UserActive {
+		if err := revokeAllSessions(user.Id); err != nil {
+			log.Println(err)
+		}
+	}
+
+	recordAudit(ctx, "user."+string(status), user.Id, map[string]any{
+		"previousStatus": user.Status,
+		"reason":         body.Reason,
+		"durationDays":   body.DurationDays,
+	})
+
+	utils.WriteResponse(ctx, http.StatusOK, fmt.Sprintf("User is now %v", status))
+}
+
+func SuspendUser(ctx *gin.Context) {
+	setUserStatus(ctx, models.UserSuspended)
+}
+
+func UnsuspendUser(ctx *gin.Context) {
+	setUserStatus(ctx, models.UserActive)
+}
+
+func BanUser(ctx *gin.Context) {
+	setUserStatus(ctx, models.UserBanned)
+}
+
+func ChangeUserRole(ctx *gin.Context) {
+	var body struct {
+		Role models.Role `json:"role" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !body.Role.Valid() {
+		utils.WriteResponse(ctx, http.StatusBadRequest, fmt.Sprintf("Unknown role %v", body.Role))
+		return
+	}
+
+	user, ok := findTargetUser(ctx)
+
+	if !ok || isSelf(ctx, user) {
+		return
+	}
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{
+			"$set": bson.M{
+				"role":      body.Role,
+				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	).Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to change role")
+		return
+	}
+
+	recordAudit(ctx, "user.role-changed", user.Id, map[string]any{
+		"from": user.Role,
+		"to":   body.Role,
+	})
+
+	utils.WriteResponse(ctx, http.StatusOK, "Role changed")
+}
+
+/*
+Signs the user out everywhere and makes them pick a new password through
+the reset email before they can login again
+*/
+func ForcePasswordReset(ctx *gin.Context) {
+	user, ok := findTargetUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{
+			"$set": bson.M{
+				"passwordResetRequired": true,
+				"updatedAt":             primitive.NewDateTimeFromTime(time.Now()),
+			},
+			"$inc": bson.M{"tokenVersion": 1},
+		},
+	).Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to force password reset")
+		return
+	}
+
+	if err := revokeAllSessions(user.Id); err != nil {
+		log.Println(err)
+	}
+
+	if err := sendPasswordResetEmail(user); err != nil {
+		log.Println(err)
+	}
+
+	recordAudit(ctx, "user.password-reset-forced", user.Id, nil)
+
+	utils.WriteResponse(ctx, http.StatusOK, "User has to reset their password")
+}
+
+func UnlockUser(ctx *gin.Context) {
+	user, ok := findTargetUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	if err := clearLoginFailures(accountAttemptKey(user.Email)); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to unlock user")
+		return
+	}
+
+	recordAudit(ctx, "user.unlocked", user.Id, nil)
+
+	utils.WriteResponse(ctx, http.StatusOK, "User unlocked")
+}
+
+/*
+Issues a short lived bearer token that acts as the user. The token also
+names the admin, every request made with it is audit logged and account
+takeover actions are blocked. No refresh token is handed out.
+*/
+func ImpersonateUser(ctx *gin.Context) {
+	user, ok := findTargetUser(ctx)
+
+	if !ok || isSelf(ctx, user) {
+		return
+	}
+
+	if user.Role == models.RoleAdmin {
+		utils.WriteResponse(ctx, http.StatusForbidden, "Admins can't be impersonated")
+		return
+	}
+
+	if !user.Active() {
+		utils.WriteResponse(ctx, http.StatusBadRequest, fmt.Sprintf("Account is %v", user.Status))
+		return
+	}
+
+	userFromCtx, _ := ctx.Get("user")
+	admin := userFromCtx.(models.User)
+
+	expiresAt := time.Now().Add(impersonationTTL)
+	session := models.Session{
+		User:         user.Id,
+		UserAgent:    ctx.Request.UserAgent(),
+		IP:           ctx.ClientIP(),
+		TokenVersion: user.TokenVersion,
+		Impersonator: &admin.Id,
+		ExpiresAt:    primitive.NewDateTimeFromTime(expiresAt),
+	}
+
+	if _, err := session.Insert(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to impersonate user")
+		return
+	}
+
+	token, err := utils.EncodeImpersonationJWT(user.Id.Hex(), admin.Id.Hex(), session.Id.Hex(), user.TokenVersion, expiresAt)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to impersonate user")
+		return
+	}
+
+	recordAudit(ctx, "user.impersonated", user.Id, map[string]any{"session": session.Id})
+
+	utils.WriteResponse(ctx, http.StatusOK, "Impersonating "+user.Name, gin.H{
+		"token":     token,
+		"expiresAt": session.ExpiresAt,
+	})
+}
+
+func GetAuditLogs(ctx *gin.Context) {
+	filter := bson.M{}
+
+	for _, field := range []string{"actor", "target"} {
+		if value := ctx.Query(field); value != "" {
+			id, err := primitive.ObjectIDFromHex(value)
+
+			if err != nil {
+				utils.WriteResponse(ctx, http.StatusBadRequest, fmt.Sprintf("Invalid %v id", field))
+				return
+			}
+
+			filter[field] = id
+		}
+	}
+
+	if action := ctx.Query("action"); action != "" {
+		filter["action"] = action
+	}
+
+	page, limit, ok := paginate(ctx)
+
+	if !ok {
+		return
+	}
+
+	total, err := db.Count(context.Background(), models.AuditLogCollection, filter)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve audit logs")
+		return
+	}
+
+	options := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := db.Find(context.Background(), models.AuditLogCollection, filter, options)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve audit logs")
+		return
+	}
+
+	entries := []models.AuditLog{}
+
+	if err := cursor.All(context.Background(), &entries); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve audit logs")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Audit logs retrieved", paginated(entries, page, limit, total))
+}
@@ -0,0 +1,211 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func CreateAPIKey(ctx *gin.Context) {
+	var body struct {
+		Name          string         `json:"name" binding:"required"`
+		Scopes        []models.Scope `json:"scopes" binding:"required,min=1"`
+		ExpiresInDays int            `json:"expiresInDays" binding:"min=0"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, scope := range body.Scopes {
+		if !scope.Valid() {
+			utils.WriteResponse(ctx, http.StatusBadRequest, fmt.Sprintf("Unknown scope %v", scope))
+			return
+		}
+	}
+
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	rawKey, prefix, err := utils.GenerateAPIKey()
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to create api key")
+		return
+	}
+
+	key := models.APIKey{
+		User:   user.Id,
+		Name:   body.Name,
+		Prefix: prefix,
+		Hash:   utils.HashToken(rawKey),
+		Scopes: body.Scopes,
+	}
+
+	if body.ExpiresInDays > 0 {
+		expiresAt := primitive.NewDateTimeFromTime(time.Now().AddDate(0, 0, body.ExpiresInDays))
+		key.ExpiresAt = &expiresAt
+	}
+
+	if _, err := key.Insert(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to create api key")
+		return
+	}
+
+	authEvent(ctx, models.EventAPIKeyCreated, user.Id, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusCreated, "Api key created, it won't be shown again", gin.H{
+		"key":    rawKey,
+		"apiKey": key,
+	})
+}
+
+func GetAPIKeys(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	options := options.Find().SetSort(bson.M{"createdAt": -1})
+	cursor, err := db.Find(
+		context.Background(),
+		models.APIKeyCollection,
+		bson.M{"user": user.Id},
+		options,
+	)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve api keys")
+		return
+	}
+
+	keys := []models.APIKey{}
+
+	if err := cursor.All(context.Background(), &keys); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve api keys")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Api keys retrieved", keys)
+}
+
+func UpdateAPIKey(ctx *gin.Context) {
+	keyId, err := primitive.ObjectIDFromHex(ctx.Param("keyId"))
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid api key id")
+		return
+	}
+
+	var body struct {
+		Name   string         `json:"name"`
+		Scopes []models.Scope `json:"scopes"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updates := bson.M{
+		"$set": bson.M{
+			"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+		},
+	}
+
+	if body.Name != "" {
+		updates["$set"].(bson.M)["name"] = body.Name
+	}
+
+	if len(body.Scopes) > 0 {
+		for _, scope := range body.Scopes {
+			if !scope.Valid() {
+				utils.WriteResponse(ctx, http.StatusBadRequest, fmt.Sprintf("Unknown scope %v", scope))
+				return
+			}
+		}
+
+		updates["$set"].(bson.M)["scopes"] = body.Scopes
+	}
+
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	result := db.UpdateOne(
+		context.Background(),
+		models.APIKeyCollection,
+		bson.M{
+			"_id":  keyId,
+			"user": user.Id,
+		},
+		updates,
+		options,
+	)
+
+	if err := result.Err(); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusNotFound, "Api key not found")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to update api key")
+		return
+	}
+
+	var key models.APIKey
+	result.Decode(&key)
+
+	utils.WriteResponse(ctx, http.StatusOK, "Api key updated", key)
+}
+
+func DeleteAPIKey(ctx *gin.Context) {
+	keyId, err := primitive.ObjectIDFromHex(ctx.Param("keyId"))
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid api key id")
+		return
+	}
+
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	result := db.DeleteOne(
+		context.Background(),
+		models.APIKeyCollection,
+		bson.M{
+			"_id":  keyId,
+			"user": user.Id,
+		})
+
+	if err := result.Err(); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusNotFound, "Api key not found")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to delete api key")
+		return
+	}
+
+	authEvent(ctx, models.EventAPIKeyDeleted, user.Id, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusOK, "Api key deleted")
+}
@@ -0,0 +1,150 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxAvatarSize = 5 << 20
+
+var avatarTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+func deleteAvatarAsset(publicId string) {
+	result, err := utils.DeleteFile(publicId, api.Image)
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if result.Error.Message != "" {
+		log.Println(result.Error.Message)
+	}
+}
+
+func ChangeAvatar(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	formFile, err := ctx.FormFile("avatar")
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Avatar file is required")
+		return
+	}
+
+	if formFile.Size > maxAvatarSize {
+		utils.WriteResponse(ctx, http.StatusRequestEntityTooLarge, "Avatar must be at most 5MB")
+		return
+	}
+
+	file, err := formFile.Open()
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to open file")
+		return
+	}
+
+	defer file.Close()
+
+	// Trust the file's bytes rather than the client supplied content type
+	header := make([]byte, 512)
+	read, _ := io.ReadFull(file, header)
+
+	if !avatarTypes[http.DetectContentType(header[:read])] {
+		utils.WriteResponse(ctx, http.StatusUnsupportedMediaType, "Avatar must be a jpeg, png, gif or webp image")
+		return
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to read file")
+		return
+	}
+
+	uploadResult, err := utils.UploadFile(file)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to upload file")
+		return
+	}
+
+	// The document from before the update tells which avatar was replaced,
+	// the one in the context may be stale after concurrent changes
+	var previous models.User
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{
+			"$set": bson.M{
+				"avatar":    uploadResult.PublicID,
+				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	).Decode(&previous); err != nil {
+		log.Println(err)
+		deleteAvatarAsset(uploadResult.PublicID)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to change avatar")
+		return
+	}
+
+	if previous.Avatar != "" {
+		deleteAvatarAsset(previous.Avatar)
+	}
+
+	user.Avatar = uploadResult.PublicID
+	utils.WriteResponse(ctx, http.StatusOK, "Changed avatar", user.AvatarURLs())
+}
+
+func DeleteAvatar(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	var previous models.User
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id, "avatar": bson.M{"$nin": bson.A{"", nil}}},
+		bson.M{
+			"$set": bson.M{
+				"avatar":    "",
+				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	).Decode(&previous); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusNotFound, "You don't have an avatar")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to remove avatar")
+		return
+	}
+
+	deleteAvatarAsset(previous.Avatar)
+
+	utils.WriteResponse(ctx, http.StatusOK, "Removed avatar")
+}
@@ -84,8 +84,8 @@ func AddPage(ctx *gin.Context) {
 	userFromCtx, _ := ctx.Get("user")
 	user := userFromCtx.(models.User)
 
-	if book.Author != user.Id {
-		utils.WriteResponse(ctx, http.StatusUnauthorized, "You can't add page to this book")
+	if !models.CanModifyBook(user, book, models.PermissionEditAnyBook) {
+		utils.WriteResponse(ctx, http.StatusForbidden, "You can't add page to this book")
 		return
 	}
 
@@ -173,8 +173,8 @@ func UpdatePage(ctx *gin.Context) {
 	userFromCtx, _ := ctx.Get("user")
 	user := userFromCtx.(models.User)
 
-	if book.Author != user.Id {
-		utils.WriteResponse(ctx, http.StatusUnauthorized, "You can't update page from this book")
+	if !models.CanModifyBook(user, book, models.PermissionEditAnyBook) {
+		utils.WriteResponse(ctx, http.StatusForbidden, "You can't update page from this book")
 		return
 	}
 
@@ -264,8 +264,8 @@ func DeletePage(ctx *gin.Context) {
 	userFromCtx, _ := ctx.Get("user")
 	user := userFromCtx.(models.User)
 
-	if book.Author != user.Id {
-		utils.WriteResponse(ctx, http.StatusUnauthorized, "You can't delete page from this book")
+	if !models.CanModifyBook(user, book, models.PermissionEditAnyBook) {
+		utils.WriteResponse(ctx, http.StatusForbidden, "You can't delete page from this book")
 		return
 	}
 
@@ -351,8 +351,8 @@ func UpdateBook(ctx *gin.Context) {
 	userFromCtx, _ := ctx.Get("user")
 	user := userFromCtx.(models.User)
 
-	if book.Author != user.Id {
-		utils.WriteResponse(ctx, http.StatusUnauthorized, "You can't update this book")
+	if !models.CanModifyBook(user, book, models.PermissionEditAnyBook) {
+		utils.WriteResponse(ctx, http.StatusForbidden, "You can't update this book")
 		return
 	}
 
@@ -427,8 +427,8 @@ func DeleteBook(ctx *gin.Context) {
 	userFromCtx, _ := ctx.Get("user")
 	user := userFromCtx.(models.User)
 
-	if book.Author != user.Id {
-		utils.WriteResponse(ctx, http.StatusUnauthorized, "You can't delete this book")
+	if !models.CanModifyBook(user, book, models.PermissionDeleteAnyBook) {
+		utils.WriteResponse(ctx, http.StatusForbidden, "You can't delete this book")
 		return
 	}
 
@@ -540,8 +540,8 @@ func ChangeBookCover(ctx *gin.Context) {
 	userFromCtx, _ := ctx.Get("user")
 	user := userFromCtx.(models.User)
 
-	if book.Author != user.Id {
-		utils.WriteResponse(ctx, http.StatusUnauthorized, "You can't change this book's cover")
+	if !models.CanModifyBook(user, book, models.PermissionEditAnyBook) {
+		utils.WriteResponse(ctx, http.StatusForbidden, "You can't change this book's cover")
 		return
 	}
 
@@ -634,8 +634,8 @@ func ChangePageCover(ctx *gin.Context) {
 	userFromCtx, _ := ctx.Get("user")
 	user := userFromCtx.(models.User)
 
-	if book.Author != user.Id {
-		utils.WriteResponse(ctx, http.StatusUnauthorized, "You can't change this page's cover")
+	if !models.CanModifyBook(user, book, models.PermissionEditAnyBook) {
+		utils.WriteResponse(ctx, http.StatusForbidden, "You can't change this page's cover")
 		return
 	}
 
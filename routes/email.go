@@ -0,0 +1,186 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+/*
+Starts an email change. The new address only replaces the current one
+once the link sent to it is opened.
+*/
+func ChangeEmail(ctx *gin.Context) {
+	var body struct {
+		reauthentication
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	body.Email = models.NormalizeEmail(body.Email)
+	user, ok := loadFullUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	if !reauthenticate(ctx, user, body.reauthentication, models.EventEmailChange) {
+		return
+	}
+
+	if body.Email == user.Email {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "This is already your email")
+		return
+	}
+
+	taken := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"email": body.Email},
+	)
+
+	if taken.Err() == nil {
+		utils.WriteResponse(ctx, http.StatusConflict, "User with email already exists")
+		return
+	}
+
+	if err := sendEmailChangeEmail(user, body.Email); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to send confirmation email")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Confirmation link sent to "+body.Email)
+}
+
+func sendEmailChangeEmail(user models.User, email string) error {
+	// Only the latest requested address can be confirmed
+	if err := discardTokens(user.Id, models.TokenEmailChange); err != nil {
+		return err
+	}
+
+	raw, err := utils.GenerateToken(32)
+
+	if err != nil {
+		return err
+	}
+
+	token := models.Token{
+		User:      user.Id,
+		Purpose:   models.TokenEmailChange,
+		Hash:      utils.HashToken(raw),
+		Email:     email,
+		ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(emailChangeTTL)),
+	}
+
+	if _, err := token.Insert(); err != nil {
+		return err
+	}
+
+	return utils.SendMail(
+		email,
+		"Confirm your new email",
+		fmt.Sprintf(
+			"Hi %v,\n\nConfirm this as the new email address of your account by opening the link below.\n\n%v",
+			user.Name,
+			utils.AppURL("/api/v1/users/email/confirm?token="+raw),
+		),
+	)
+}
+
+func ConfirmEmailChange(ctx *gin.Context) {
+	raw := ctx.Query("token")
+
+	if raw == "" {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Confirmation token missing")
+		return
+	}
+
+	token, err := consumeToken(raw, models.TokenEmailChange)
+
+	if err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusBadRequest, "Confirmation link is invalid or has expired")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	// Returns the document before the swap so the old address can be notified
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": token.User},
+		bson.M{
+			"$set": bson.M{
+				"email":         token.Email,
+				"emailVerified": true,
+				"updatedAt":     primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	)
+
+	if err := result.Err(); err != nil {
+
+		// Someone else registered or confirmed the address in the meantime
+		if mongo.IsDuplicateKeyError(err) {
+			utils.WriteResponse(ctx, http.StatusConflict, "User with email already exists")
+			return
+		}
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusNotFound, "User not found")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to change email")
+		return
+	}
+
+	var user models.User
+	result.Decode(&user)
+
+	// Links mailed to the old address must not work anymore
+	for _, purpose := range []models.TokenPurpose{models.TokenPasswordReset, models.TokenEmailVerification, models.TokenMagicLink} {
+		if err := discardTokens(user.Id, purpose); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if err := utils.SendMail(
+		user.Email,
+		"Your email was changed",
+		fmt.Sprintf(
+			"Hi %v,\n\nThe email address of your account was changed to %v. If you didn't do this, contact support immediately.",
+			user.Name,
+			token.Email,
+		),
+	); err != nil {
+		log.Println(err)
+	}
+
+	authEvent(ctx, models.EventEmailChange, user.Id, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusOK, "Email changed")
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/middlewares"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func authEvent(ctx *gin.Context, eventType models.AuthEventType, userId primitive.ObjectID, outcome models.AuthOutcome, reason string) {
+	middlewares.RecordAuthEvent(ctx, models.AuthEvent{
+		User:    userId,
+		Type:    eventType,
+		Outcome: outcome,
+		Reason:  reason,
+	})
+}
+
+/*
+Recent authentication activity of the current user, so they can spot
+sign-ins they don't recognize
+*/
+func GetSecurityEvents(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	listAuthEvents(ctx, bson.M{"user": user.Id})
+}
+
+/*
+Admin view over all auth events. Filters by user, type, outcome and a
+createdAt range given as RFC 3339 "from" and "to".
+*/
+func GetAuthEvents(ctx *gin.Context) {
+	filter := bson.M{}
+
+	if value := ctx.Query("user"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+
+		if err != nil {
+			utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid user id")
+			return
+		}
+
+		filter["user"] = id
+	}
+
+	for _, field := range []string{"type", "outcome", "email"} {
+		if value := ctx.Query(field); value != "" {
+			filter[field] = value
+		}
+	}
+
+	createdAt := bson.M{}
+
+	for field, operator := range map[string]string{"from": "$gte", "to": "$lte"} {
+		value := ctx.Query(field)
+
+		if value == "" {
+			continue
+		}
+
+		at, err := time.Parse(time.RFC3339, value)
+
+		if err != nil {
+			utils.WriteResponse(ctx, http.StatusBadRequest, fmt.Sprintf("Invalid %v time, use RFC 3339", field))
+			return
+		}
+
+		createdAt[operator] = primitive.NewDateTimeFromTime(at)
+	}
+
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	listAuthEvents(ctx, filter)
+}
+
+func listAuthEvents(ctx *gin.Context, filter bson.M) {
+	page, limit, ok := paginate(ctx)
+
+	if !ok {
+		return
+	}
+
+	total, err := db.Count(context.Background(), models.AuthEventCollection, filter)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve security events")
+		return
+	}
+
+	options := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := db.Find(context.Background(), models.AuthEventCollection, filter, options)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve security events")
+		return
+	}
+
+	events := []models.AuthEvent{}
+
+	if err := cursor.All(context.Background(), &events); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve security events")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Security events retrieved", paginated(events, page, limit, total))
+}
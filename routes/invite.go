@@ -0,0 +1,322 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	registrationOpen       = "open"
+	registrationInviteOnly = "invite-only"
+	registrationClosed     = "closed"
+)
+
+/*
+REGISTRATION_MODE decides who can sign up. Anything other than the known
+modes closes registration rather than silently opening it.
+*/
+func registrationMode() string {
+	switch mode := os.Getenv("REGISTRATION_MODE"); mode {
+	case "", registrationOpen:
+		return registrationOpen
+	case registrationInviteOnly:
+		return registrationInviteOnly
+	default:
+		return registrationClosed
+	}
+}
+
+/*
+Narrows filter to invites that can still be redeemed, neither revoked,
+expired nor used up
+*/
+func activeInvites(filter bson.M) bson.M {
+	filter["revoked"] = false
+	filter["$or"] = bson.A{
+		bson.M{"expiresAt": bson.M{"$exists": false}},
+		bson.M{"expiresAt": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())}},
+	}
+	filter["$expr"] = bson.M{"$lt": bson.A{"$uses", "$maxUses"}}
+
+	return filter
+}
+
+/*
+Decides whether a new account may be created. A given invite code is
+redeemed in any open mode so signups stay traceable, in invite-only mode
+it's required. The returned invite has to be passed to finishRegistration
+once the user is inserted.
+*/
+func admitRegistration(code string) (*models.Invite, int, error) {
+	mode := registrationMode()
+
+	if mode == registrationClosed {
+		return nil, http.StatusForbidden, errors.New("Registration is closed")
+	}
+
+	if code == "" {
+		if mode == registrationInviteOnly {
+			return nil, http.StatusForbidden, errors.New("An invite code is required to register")
+		}
+
+		return nil, http.StatusOK, nil
+	}
+
+	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	result := db.UpdateOne(
+		context.Background(),
+		models.InviteCollection,
+		activeInvites(bson.M{"hash": utils.HashToken(code)}),
+		bson.M{"$inc": bson.M{"uses": 1}},
+		options,
+	)
+
+	var invite models.Invite
+
+	if err := result.Decode(&invite); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, http.StatusForbidden, errors.New("Invite code is invalid, expired or used up")
+		}
+
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &invite, http.StatusOK, nil
+}
+
+/*
+Links the created user to the invite it used, or gives the use back when
+the account couldn't be created
+*/
+func finishRegistration(invite *models.Invite, userId primitive.ObjectID, created bool) {
+	if invite == nil {
+		return
+	}
+
+	update := bson.M{"$push": bson.M{"usedBy": userId}}
+
+	if !created {
+		update = bson.M{"$inc": bson.M{"uses": -1}}
+	}
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.InviteCollection,
+		bson.M{"_id": invite.Id},
+		update,
+	).Err(); err != nil {
+		log.Println(err)
+	}
+}
+
+/*
+Admins can create invites freely. Everyone else is capped by
+INVITE_MAX_USES, INVITE_MAX_DAYS and INVITE_MAX_ACTIVE so one account
+can't open the doors for everybody.
+*/
+func CreateInvite(ctx *gin.Context) {
+	var body struct {
+		Note          string `json:"note"`
+		MaxUses       int    `json:"maxUses" binding:"min=0"`
+		ExpiresInDays int    `json:"expiresInDays" binding:"min=0"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	if body.MaxUses == 0 {
+		body.MaxUses = 1
+	}
+
+	if !user.Role.Can(models.PermissionManageUsers) {
+		maxUses := envInt("INVITE_MAX_USES", 1)
+		maxDays := envInt("INVITE_MAX_DAYS", 30)
+
+		if body.MaxUses > maxUses {
+			utils.WriteResponse(ctx, http.StatusBadRequest, fmt.Sprintf("Invites can be used at most %v times", maxUses))
+			return
+		}
+
+		if body.ExpiresInDays == 0 || body.ExpiresInDays > maxDays {
+			body.ExpiresInDays = maxDays
+		}
+
+		maxActive := envInt("INVITE_MAX_ACTIVE", 5)
+		active, err := db.Count(context.Background(), models.InviteCollection, activeInvites(bson.M{"createdBy": user.Id}))
+
+		if err != nil {
+			log.Println(err)
+			utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to create invite")
+			return
+		}
+
+		if active >= int64(maxActive) {
+			utils.WriteResponse(ctx, http.StatusConflict, fmt.Sprintf("You can have at most %v active invites, revoke one first", maxActive))
+			return
+		}
+	}
+
+	code, err := utils.GenerateToken(12)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+
+	invite := models.Invite{
+		CreatedBy: user.Id,
+		Hash:      utils.HashToken(code),
+		Note:      body.Note,
+		MaxUses:   body.MaxUses,
+	}
+
+	if body.ExpiresInDays > 0 {
+		expiresAt := primitive.NewDateTimeFromTime(time.Now().AddDate(0, 0, body.ExpiresInDays))
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if _, err := invite.Insert(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusCreated, "Invite created, the code won't be shown again", gin.H{
+		"code":   code,
+		"invite": invite,
+	})
+}
+
+func GetInvites(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	listInvites(ctx, bson.M{"createdBy": user.Id})
+}
+
+func RevokeInvite(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	revokeInvite(ctx, bson.M{"createdBy": user.Id})
+}
+
+/*
+Every invite in the system, optionally only the ones created by a user
+*/
+func ListInvites(ctx *gin.Context) {
+	filter := bson.M{}
+
+	if value := ctx.Query("createdBy"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+
+		if err != nil {
+			utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid createdBy id")
+			return
+		}
+
+		filter["createdBy"] = id
+	}
+
+	listInvites(ctx, filter)
+}
+
+func AdminRevokeInvite(ctx *gin.Context) {
+	revokeInvite(ctx, bson.M{})
+}
+
+func listInvites(ctx *gin.Context, filter bson.M) {
+	page, limit, ok := paginate(ctx)
+
+	if !ok {
+		return
+	}
+
+	total, err := db.Count(context.Background(), models.InviteCollection, filter)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve invites")
+		return
+	}
+
+	options := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := db.Find(context.Background(), models.InviteCollection, filter, options)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve invites")
+		return
+	}
+
+	invites := []models.Invite{}
+
+	if err := cursor.All(context.Background(), &invites); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve invites")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Invites retrieved", paginated(invites, page, limit, total))
+}
+
+/*
+Revokes the invite named by the :inviteId param. Revoked invites are kept
+so the accounts created with them stay traceable.
+*/
+func revokeInvite(ctx *gin.Context, filter bson.M) {
+	inviteId, err := primitive.ObjectIDFromHex(ctx.Param("inviteId"))
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid invite id")
+		return
+	}
+
+	filter["_id"] = inviteId
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.InviteCollection,
+		filter,
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+
+	if err := result.Err(); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusNotFound, "Invite not found")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to revoke invite")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Invite revoked")
+}
@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/models"
+)
+
+func TestCreateInviteActiveLimit(t *testing.T) {
+	requireDB(t)
+	t.Setenv("INVITE_MAX_ACTIVE", "2")
+
+	user := newTestUser(t)
+	router := gin.New()
+	router.POST("/invites", signedInAs(user), CreateInvite)
+	router.DELETE("/invites/:inviteId", signedInAs(user), RevokeInvite)
+
+	var created struct {
+		Invite models.Invite `json:"invite"`
+	}
+
+	for i := 0; i < 2; i++ {
+		if response := call(t, router, http.MethodPost, "/invites", gin.H{}, &created); response.Status != http.StatusCreated {
+			t.Fatalf("invite %v answered %v: %v", i+1, response.Status, response.Message)
+		}
+	}
+
+	if response := call(t, router, http.MethodPost, "/invites", gin.H{}, nil); response.Status != http.StatusConflict {
+		t.Errorf("invite past the limit answered %v", response.Status)
+	}
+
+	if response := call(t, router, http.MethodDelete, "/invites/"+created.Invite.Id.Hex(), nil, nil); response.Status != http.StatusOK {
+		t.Fatalf("revoking answered %v: %v", response.Status, response.Message)
+	}
+
+	// Revoked invites don't count
+	if response := call(t, router, http.MethodPost, "/invites", gin.H{}, nil); response.Status != http.StatusCreated {
+		t.Errorf("invite after revoking one answered %v: %v", response.Status, response.Message)
+	}
+}
@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxJobAttempts = 3
+
+var jobHandlers = map[models.JobType]func(job models.Job) error{
+	models.JobAccountDeletion: deleteAccountData,
+}
+
+/*
+Runs the job in the background, recording its progress on the job document.
+Handlers must be safe to run again since unfinished jobs are resumed.
+*/
+func startJob(job models.Job) {
+	go func() {
+		now := primitive.NewDateTimeFromTime(time.Now())
+
+		db.UpdateOne(
+			context.Background(),
+			models.JobCollection,
+			bson.M{"_id": job.Id},
+			bson.M{
+				"$set": bson.M{"status": models.JobRunning, "updatedAt": now},
+				"$inc": bson.M{"attempts": 1},
+			},
+		)
+
+		update := bson.M{
+			"status":    models.JobCompleted,
+			"error":     "",
+			"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+		}
+
+		if err := jobHandlers[job.Type](job); err != nil {
+			log.Printf("Job %v (%v) failed: %v", job.Id.Hex(), job.Type, err)
+			update["status"] = models.JobFailed
+			update["error"] = err.Error()
+		}
+
+		db.UpdateOne(
+			context.Background(),
+			models.JobCollection,
+			bson.M{"_id": job.Id},
+			bson.M{"$set": update},
+		)
+	}()
+}
+
+/*
+Picks up jobs interrupted by a restart and failed jobs that have attempts
+left
+*/
+func ResumeJobs() {
+	cursor, err := db.Find(
+		context.Background(),
+		models.JobCollection,
+		bson.M{
+			"$or": bson.A{
+				bson.M{"status": bson.M{"$in": bson.A{models.JobPending, models.JobRunning}}},
+				bson.M{"status": models.JobFailed, "attempts": bson.M{"$lt": maxJobAttempts}},
+			},
+		},
+	)
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var jobs []models.Job
+
+	if err := cursor.All(context.Background(), &jobs); err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, job := range jobs {
+		log.Printf("Resuming job %v (%v)", job.Id.Hex(), job.Type)
+		startJob(job)
+	}
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/utils"
+)
+
+/*
+Serves the verification keys as a plain JWK set so other services can
+validate our tokens
+*/
+func GetJWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, utils.JWKS())
+}
@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxLoginBackoff = 30 * time.Second
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+/*
+Compared against when the email is unknown so those requests take as long
+as a wrong password. Built lazily so it uses the configured hasher.
+*/
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = utils.HashPassword("not-a-real-password")
+	})
+
+	return dummyHash
+}
+
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+
+	return fallback
+}
+
+func lockoutDuration() time.Duration {
+	if duration, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_DURATION")); err == nil && duration > 0 {
+		return duration
+	}
+
+	return 15 * time.Minute
+}
+
+func accountAttemptKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+/*
+Returns how long the caller has to wait before trying again, zero if all
+of the keys may attempt a login now
+*/
+func loginRetryAfter(keys ...string) time.Duration {
+	var wait time.Duration
+
+	for _, key := range keys {
+		var attempt models.LoginAttempt
+
+		if err := db.FindOne(
+			context.Background(),
+			models.LoginAttemptCollection,
+			bson.M{"key": key},
+		).Decode(&attempt); err != nil {
+			continue
+		}
+
+		// Expired lockouts and counters quiet for a full lockout period
+		// start over
+		expired := time.Now().After(attempt.UpdatedAt.Time().Add(lockoutDuration()))
+
+		if attempt.LockedUntil != nil {
+			expired = time.Now().After(attempt.LockedUntil.Time())
+		}
+
+		if expired {
+			clearLoginFailures(key)
+			continue
+		}
+
+		if attempt.LockedUntil != nil {
+			wait = max(wait, time.Until(attempt.LockedUntil.Time()))
+		} else {
+			wait = max(wait, time.Until(attempt.NextAttemptAt.Time()))
+		}
+	}
+
+	return max(wait, 0)
+}
+
+func writeLockedOut(ctx *gin.Context, wait time.Duration) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	utils.WriteResponse(ctx, http.StatusTooManyRequests, "Too many failed attempts, try again later")
+}
+
+/*
+Counts a failed attempt for the key. Each failure doubles the wait before
+the next attempt and reaching the threshold locks the key out.
+*/
+func recordLoginFailure(key string, threshold int) {
+	options := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	result := db.UpdateOne(
+		context.Background(),
+		models.LoginAttemptCollection,
+		bson.M{"key": key},
+		bson.M{
+			"$inc":         bson.M{"failures": 1},
+			"$set":         bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+		},
+		options,
+	)
+
+	var attempt models.LoginAttempt
+
+	if err := result.Decode(&attempt); err != nil {
+		log.Println(err)
+		return
+	}
+
+	backoff := time.Duration(math.Pow(2, float64(attempt.Failures-1))) * time.Second
+	updates := bson.M{
+		"nextAttemptAt": primitive.NewDateTimeFromTime(time.Now().Add(min(backoff, maxLoginBackoff))),
+	}
+
+	if attempt.Failures >= threshold {
+		updates["lockedUntil"] = primitive.NewDateTimeFromTime(time.Now().Add(lockoutDuration()))
+	}
+
+	db.UpdateOne(
+		context.Background(),
+		models.LoginAttemptCollection,
+		bson.M{"_id": attempt.Id},
+		bson.M{"$set": updates},
+	)
+}
+
+func clearLoginFailures(key string) error {
+	_, err := db.DeleteMany(context.Background(), models.LoginAttemptCollection, bson.M{"key": key})
+	return err
+}
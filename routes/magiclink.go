@@ -0,0 +1,191 @@
+package routes
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	magicLinkCookie       = "magic_link_nonce"
+	magicLinkTTL          = 15 * time.Minute
+	magicLinkResendWindow = time.Minute
+)
+
+/*
+Emails a single use login link. The browser asking for it gets a nonce
+cookie and the link only works together with that cookie, so a forwarded
+or intercepted link can't be used from somewhere else.
+*/
+func RequestMagicLink(ctx *gin.Context) {
+	var body struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	nonce, err := utils.GenerateToken(32)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	// The cookie is set for unknown emails too, otherwise its absence would
+	// tell which accounts exist
+	utils.SetRedirectCookie(
+		ctx,
+		magicLinkCookie,
+		nonce,
+		int(magicLinkTTL.Seconds()),
+		"/api/v1/users/magic-link",
+	)
+
+	const message = "If an account exists for this email a login link has been sent"
+
+	var user models.User
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"email": models.NormalizeEmail(body.Email)},
+	).Decode(&user); err != nil || !user.Active() {
+
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+		}
+
+		utils.WriteResponse(ctx, http.StatusOK, message)
+		return
+	}
+
+	if err := sendMagicLink(user, nonce); err != nil {
+		log.Println(err)
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, message)
+}
+
+func sendMagicLink(user models.User, nonce string) error {
+	recent := db.FindOne(
+		context.Background(),
+		models.TokenCollection,
+		bson.M{
+			"user":      user.Id,
+			"purpose":   models.TokenMagicLink,
+			"createdAt": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now().Add(-magicLinkResendWindow))},
+		})
+
+	if recent.Err() == nil {
+		return errors.New("magic link requested again too soon")
+	}
+
+	// A new request replaces links sent to other browsers
+	if err := discardTokens(user.Id, models.TokenMagicLink); err != nil {
+		return err
+	}
+
+	raw, err := utils.GenerateToken(32)
+
+	if err != nil {
+		return err
+	}
+
+	token := models.Token{
+		User:      user.Id,
+		Purpose:   models.TokenMagicLink,
+		Hash:      utils.HashToken(raw),
+		Nonce:     utils.HashToken(nonce),
+		ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(magicLinkTTL)),
+	}
+
+	if _, err := token.Insert(); err != nil {
+		return err
+	}
+
+	return utils.SendMail(
+		user.Email,
+		"Your login link",
+		fmt.Sprintf(
+			"Hi %v,\n\nOpen the link below in the same browser to log in. It expires in %v minutes.\n\n%v",
+			user.Name,
+			int(magicLinkTTL.Minutes()),
+			utils.AppURL("/api/v1/users/magic-link/consume?token="+raw),
+		),
+	)
+}
+
+func ConsumeMagicLink(ctx *gin.Context) {
+	raw := ctx.Query("token")
+
+	if raw == "" {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Login token missing")
+		return
+	}
+
+	token, err := findToken(raw, models.TokenMagicLink)
+
+	if err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusBadRequest, "Login link is invalid or has expired")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	nonce, _ := ctx.Cookie(magicLinkCookie)
+
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(utils.HashToken(nonce)), []byte(token.Nonce)) != 1 {
+		authEvent(ctx, models.EventMagicLinkLogin, token.User, models.OutcomeFailure, "browser mismatch")
+		utils.WriteResponse(ctx, http.StatusForbidden, "Open the link in the browser you requested it from")
+		return
+	}
+
+	if _, err := consumeToken(raw, models.TokenMagicLink); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Login link is invalid or has expired")
+		return
+	}
+
+	utils.SetRedirectCookie(ctx, magicLinkCookie, "", -1, "/api/v1/users/magic-link")
+
+	// Opening the link proves the user controls the address
+	var user models.User
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": token.User},
+		bson.M{
+			"$set": bson.M{
+				"emailVerified": true,
+				"updatedAt":     primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	).Decode(&user); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Login link is invalid or has expired")
+		return
+	}
+
+	completeLogin(ctx, user, models.EventMagicLinkLogin)
+}
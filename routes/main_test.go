@@ -0,0 +1,111 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Handlers talking to the database run against TEST_MONGODB_URI, each test
+binary in a database of its own that is dropped afterwards. Without it
+those tests are skipped.
+*/
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	os.Setenv("JWT_SECRET", "test-secret")
+	os.Setenv("WEBAUTHN_RP_ID", "localhost")
+	os.Setenv("WEBAUTHN_ORIGINS", "http://localhost:5000")
+	utils.InitializeJWT(make(chan string, 1))
+
+	uri := os.Getenv("TEST_MONGODB_URI")
+
+	if uri == "" {
+		os.Exit(m.Run())
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db.Db = client.Database("gin-basic-api-test-" + primitive.NewObjectID().Hex())
+	code := m.Run()
+
+	db.Db.Drop(context.Background())
+	client.Disconnect(context.Background())
+
+	os.Exit(code)
+}
+
+func requireDB(t *testing.T) {
+	t.Helper()
+
+	if db.Db == nil {
+		t.Skip("TEST_MONGODB_URI isn't set")
+	}
+}
+
+type testResponse struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+/*
+Sends body as JSON through the router and decodes the response data into
+data when it's given
+*/
+func call(t *testing.T, router http.Handler, method string, path string, body any, data any) testResponse {
+	t.Helper()
+
+	var payload bytes.Buffer
+
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request := httptest.NewRequest(method, path, &payload)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	var response testResponse
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%v %v answered %v: %s", method, path, recorder.Code, recorder.Body)
+	}
+
+	if data != nil && recorder.Code < 300 {
+		if err := json.Unmarshal(response.Data, data); err != nil {
+			t.Fatalf("%v %v data: %v", method, path, err)
+		}
+	}
+
+	return response
+}
+
+// Stands in for Authorize
+func signedInAs(user models.User) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user.Password = ""
+		ctx.Set("user", user)
+	}
+}
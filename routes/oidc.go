@@ -0,0 +1,261 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const oidcStateCookie = "oidc_state"
+
+/*
+Starts the authorization code flow and redirects to the provider. The
+state is also set in a cookie so the callback only completes in the browser
+that started it. An ?invite= code is kept for when the login creates a new
+account.
+*/
+func OIDCLogin(ctx *gin.Context) {
+	provider, ok := utils.GetOIDCProvider(ctx.Param("provider"))
+
+	if !ok {
+		utils.WriteResponse(ctx, http.StatusNotFound, "Unknown login provider")
+		return
+	}
+
+	state, stateErr := utils.GenerateToken(32)
+	nonce, nonceErr := utils.GenerateToken(32)
+	verifier, verifierErr := utils.GenerateToken(32)
+
+	if err := errors.Join(stateErr, nonceErr, verifierErr); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce, verifier)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusBadGateway, "Login provider is unavailable")
+		return
+	}
+
+	pending := models.OIDCState{
+		Provider:     provider.Name,
+		StateHash:    utils.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		InviteCode:   ctx.Query("invite"),
+	}
+
+	if _, err := pending.Insert(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.SetRedirectCookie(
+		ctx,
+		oidcStateCookie,
+		state,
+		int(models.OIDCStateTTL.Seconds()),
+		"/api/v1/users/oidc",
+	)
+
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+func OIDCCallback(ctx *gin.Context) {
+	provider, ok := utils.GetOIDCProvider(ctx.Param("provider"))
+
+	if !ok {
+		utils.WriteResponse(ctx, http.StatusNotFound, "Unknown login provider")
+		return
+	}
+
+	if errorCode := ctx.Query("error"); errorCode != "" {
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Login was cancelled: "+errorCode)
+		return
+	}
+
+	state := ctx.Query("state")
+	cookie, err := ctx.Cookie(oidcStateCookie)
+
+	if err != nil || state == "" || cookie != state {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Login request is invalid or has expired")
+		return
+	}
+
+	utils.SetRedirectCookie(ctx, oidcStateCookie, "", -1, "/api/v1/users/oidc")
+
+	// Deleting the state makes it single use
+	var pending models.OIDCState
+
+	if err := db.DeleteOne(
+		context.Background(),
+		models.OIDCStateCollection,
+		bson.M{
+			"stateHash": utils.HashToken(state),
+			"provider":  provider.Name,
+			"expiresAt": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+		},
+	).Decode(&pending); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Login request is invalid or has expired")
+		return
+	}
+
+	idToken, err := provider.Exchange(ctx.Query("code"), pending.CodeVerifier)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusBadGateway, "Failed to complete login with provider")
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(idToken, pending.Nonce)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Invalid identity token")
+		return
+	}
+
+	user, status, err := findOrCreateOIDCUser(provider.Name, claims, pending.InviteCode)
+
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.Println(err)
+			utils.WriteResponse(ctx, status, "Something went wrong")
+			return
+		}
+
+		utils.WriteResponse(ctx, status, err.Error())
+		return
+	}
+
+	completeLogin(ctx, user, models.EventOIDCLogin)
+}
+
+/*
+Resolves the local user for an external identity. Existing identities log
+straight in, a verified email matching a verified local account gets linked
+and otherwise a new user is created if registration admits it.
+*/
+func findOrCreateOIDCUser(provider string, claims *utils.IDTokenClaims, inviteCode string) (models.User, int, error) {
+	var user models.User
+
+	err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{
+			"identities": bson.M{
+				"$elemMatch": bson.M{
+					"provider": provider,
+					"subject":  claims.Subject,
+				},
+			},
+		},
+	).Decode(&user)
+
+	if err == nil {
+		return user, http.StatusOK, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return user, http.StatusInternalServerError, err
+	}
+
+	claims.Email = models.NormalizeEmail(claims.Email)
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return user, http.StatusForbidden, errors.New("Provider didn't share a verified email")
+	}
+
+	identity := models.Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		LinkedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+
+	// Only verified accounts get linked, otherwise whoever registered the
+	// email first could take over the provider login.
+	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{
+			"email":         claims.Email,
+			"emailVerified": true,
+		},
+		bson.M{
+			"$push": bson.M{"identities": identity},
+			"$set":  bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+		},
+		options,
+	)
+
+	if err := result.Err(); err == nil {
+		result.Decode(&user)
+		return user, http.StatusOK, nil
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return user, http.StatusInternalServerError, err
+	}
+
+	if db.FindOne(context.Background(), models.UserCollection, bson.M{"email": claims.Email}).Err() == nil {
+		return user, http.StatusConflict, errors.New("An account with this email exists, verify it to login with this provider")
+	}
+
+	password, err := utils.GenerateToken(32)
+
+	if err != nil {
+		return user, http.StatusInternalServerError, err
+	}
+
+	invite, status, err := admitRegistration(inviteCode)
+
+	if err != nil {
+		return user, status, err
+	}
+
+	name := claims.Name
+
+	if name == "" {
+		name = claims.Email
+	}
+
+	// The random password is never shared, these accounts sign in through
+	// the provider or reset it by email.
+	user = models.User{
+		Name:          name,
+		Email:         claims.Email,
+		Password:      password,
+		Role:          models.DefaultRole,
+		EmailVerified: true,
+		Identities:    []models.Identity{identity},
+	}
+
+	if invite != nil {
+		user.Invite = &invite.Id
+	}
+
+	_, err = user.Insert()
+	finishRegistration(invite, user.Id, err == nil)
+
+	if err != nil {
+		return user, http.StatusInternalServerError, err
+	}
+
+	return user, http.StatusOK, nil
+}
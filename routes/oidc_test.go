@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/utils"
+)
+
+func TestOIDCCallbackRejectsBadState(t *testing.T) {
+	t.Setenv("OIDC_PROVIDERS", "test")
+	t.Setenv("OIDC_TEST_ISSUER", "http://localhost:8080/default")
+	t.Setenv("OIDC_TEST_CLIENT_ID", "client")
+	t.Setenv("OIDC_TEST_REDIRECT_URL", "http://localhost:5000/api/v1/users/oidc/test/callback")
+	utils.InitializeOIDC(make(chan string, 1))
+
+	router := gin.New()
+	router.GET("/api/v1/users/oidc/:provider/callback", OIDCCallback)
+
+	tests := []struct {
+		name   string
+		query  string
+		cookie string
+	}{
+		{name: "no state", query: "code=code", cookie: "state"},
+		{name: "no cookie", query: "code=code&state=state"},
+		{name: "other browser", query: "code=code&state=state", cookie: "another-state"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/v1/users/oidc/test/callback?"+test.query, nil)
+
+			if test.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: test.cookie})
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("status = %v, want %v", recorder.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
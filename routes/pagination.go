@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/utils"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 50
+	// Keeps the skip far from overflowing and deep scans out of reach
+	maxPage = 10000
+)
+
+/*
+Reads ?page= and ?limit= falling back to sane defaults. Pages past maxPage
+are refused with a 400 written to the response.
+*/
+func paginate(ctx *gin.Context) (int64, int64, bool) {
+	page, err := strconv.ParseInt(ctx.Query("page"), 10, 64)
+
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	if page > maxPage {
+		utils.WriteResponse(ctx, http.StatusBadRequest, fmt.Sprintf("Page can be at most %v", maxPage))
+		return 0, 0, false
+	}
+
+	limit, err := strconv.ParseInt(ctx.Query("limit"), 10, 64)
+
+	if err != nil || limit < 1 {
+		limit = defaultPageSize
+	}
+
+	return page, min(limit, maxPageSize), true
+}
+
+func paginated(items any, page int64, limit int64, total int64) gin.H {
+	return gin.H{
+		"items": items,
+		"page":  page,
+		"limit": limit,
+		"total": total,
+		"pages": (total + limit - 1) / limit,
+	}
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		query string
+		page  int64
+		limit int64
+		ok    bool
+	}{
+		{query: "", page: 1, limit: defaultPageSize, ok: true},
+		{query: "page=3&limit=20", page: 3, limit: 20, ok: true},
+		{query: "page=-2&limit=0", page: 1, limit: defaultPageSize, ok: true},
+		{query: "limit=1000", page: 1, limit: maxPageSize, ok: true},
+		{query: "page=10000", page: maxPage, limit: defaultPageSize, ok: true},
+		{query: "page=10001"},
+		{query: "page=9223372036854775807&limit=50"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/?"+test.query, nil)
+
+			page, limit, ok := paginate(ctx)
+
+			if page != test.page || limit != test.limit || ok != test.ok {
+				t.Errorf("paginate() = %v, %v, %v, want %v, %v, %v", page, limit, ok, test.page, test.limit, test.ok)
+			}
+
+			if !ok && recorder.Code != http.StatusBadRequest {
+				t.Errorf("status = %v, want %v", recorder.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,570 @@
+package routes
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const passkeyChallengeTTL = 5 * time.Minute
+
+/*
+Credentials of the user in the shape allowCredentials and
+excludeCredentials expect
+*/
+func passkeyDescriptors(userId primitive.ObjectID) ([]gin.H, error) {
+	cursor, err := db.Find(context.Background(), models.PasskeyCollection, bson.M{"user": userId})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var passkeys []models.Passkey
+
+	if err := cursor.All(context.Background(), &passkeys); err != nil {
+		return nil, err
+	}
+
+	descriptors := make([]gin.H, 0, len(passkeys))
+
+	for _, passkey := range passkeys {
+		descriptors = append(descriptors, gin.H{
+			"type":       "public-key",
+			"id":         passkey.CredentialId,
+			"transports": passkey.Transports,
+		})
+	}
+
+	return descriptors, nil
+}
+
+/*
+Options for navigator.credentials.get(). allowCredentials stays empty for
+usernameless logins so the authenticator offers its discoverable passkeys.
+*/
+func passkeyRequestOptions(challenge string, allowCredentials []gin.H, userVerification string) gin.H {
+	if allowCredentials == nil {
+		allowCredentials = []gin.H{}
+	}
+
+	return gin.H{
+		"publicKey": gin.H{
+			"challenge":        challenge,
+			"rpId":             utils.GetWebAuthnConfig().RPID,
+			"timeout":          passkeyChallengeTTL.Milliseconds(),
+			"userVerification": userVerification,
+			"allowCredentials": allowCredentials,
+		},
+	}
+}
+
+/*
+Checks an assertion against the challenge issued for userId, pass
+primitive.NilObjectID for usernameless logins. On success the stored sign
+count is moved forward, a counter that didn't increase means the
+authenticator was likely cloned and the assertion is refused.
+*/
+func verifyPasskeyAssertion(credential utils.CredentialResponse, userId primitive.ObjectID, requireUV bool) (models.Passkey, error) {
+	var passkey models.Passkey
+
+	clientData, err := utils.ParseClientData(credential.Response.ClientDataJSON, utils.WebAuthnGet)
+
+	if err != nil {
+		return passkey, err
+	}
+
+	token, err := consumeToken(clientData.Challenge, models.TokenPasskeyAssertion)
+
+	if err != nil || token.User != userId {
+		return passkey, errors.New("passkey challenge is invalid or has expired")
+	}
+
+	filter := bson.M{"credentialId": strings.TrimRight(credential.Id, "=")}
+
+	if !userId.IsZero() {
+		filter["user"] = userId
+	}
+
+	if err := db.FindOne(context.Background(), models.PasskeyCollection, filter).Decode(&passkey); err != nil {
+		return passkey, errors.New("unknown passkey")
+	}
+
+	if credential.Response.UserHandle != "" {
+		handle, err := utils.DecodeBase64URL(credential.Response.UserHandle)
+
+		if err != nil || string(handle) != string(passkey.User[:]) {
+			return passkey, errors.New("passkey belongs to another user")
+		}
+	}
+
+	data, err := utils.VerifyAssertion(credential, passkey.PublicKey, requireUV)
+
+	if err != nil {
+		return passkey, err
+	}
+
+	// Authenticators without a counter always report zero
+	if (data.SignCount != 0 || passkey.SignCount != 0) && data.SignCount <= passkey.SignCount {
+		return passkey, errors.New("sign count didn't increase, authenticator may be cloned")
+	}
+
+	// Conditional on the old count so two concurrent assertions can't both pass
+	if err := db.UpdateOne(
+		context.Background(),
+		models.PasskeyCollection,
+		bson.M{
+			"_id":       passkey.Id,
+			"signCount": passkey.SignCount,
+		},
+		bson.M{
+			"$set": bson.M{
+				"signCount":  data.SignCount,
+				"backedUp":   data.BackedUp(),
+				"lastUsedAt": primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	).Err(); err != nil {
+		return passkey, errors.New("passkey was used concurrently")
+	}
+
+	return passkey, nil
+}
+
+/*
+Options for navigator.credentials.create(). Attestation isn't requested,
+already registered credentials are excluded so an authenticator can't be
+added twice.
+*/
+func PasskeyRegistrationOptions(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	challenge, err := issueToken(user.Id, models.TokenPasskeyCreation, passkeyChallengeTTL)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	exclude, err := passkeyDescriptors(user.Id)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	config := utils.GetWebAuthnConfig()
+	algorithms := make([]gin.H, 0, len(utils.COSEAlgorithms))
+
+	for _, algorithm := range utils.COSEAlgorithms {
+		algorithms = append(algorithms, gin.H{"type": "public-key", "alg": algorithm})
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Passkey registration options", gin.H{
+		"publicKey": gin.H{
+			"challenge": challenge,
+			"rp": gin.H{
+				"id":   config.RPID,
+				"name": config.RPName,
+			},
+			"user": gin.H{
+				"id":          base64.RawURLEncoding.EncodeToString(user.Id[:]),
+				"name":        user.Email,
+				"displayName": user.Name,
+			},
+			"pubKeyCredParams": algorithms,
+			"timeout":          passkeyChallengeTTL.Milliseconds(),
+			"attestation":      "none",
+			"authenticatorSelection": gin.H{
+				"residentKey":      "preferred",
+				"userVerification": "preferred",
+			},
+			"excludeCredentials": exclude,
+		},
+	})
+}
+
+func RegisterPasskey(ctx *gin.Context) {
+	var body struct {
+		reauthentication
+		Name       string                   `json:"name" binding:"required"`
+		Credential utils.CredentialResponse `json:"credential" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := loadFullUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	if !reauthenticate(ctx, user, body.reauthentication, models.EventPasskeyAdded) {
+		return
+	}
+
+	clientData, err := utils.ParseClientData(body.Credential.Response.ClientDataJSON, utils.WebAuthnCreate)
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := consumeToken(clientData.Challenge, models.TokenPasskeyCreation)
+
+	if err != nil || token.User != user.Id {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Passkey challenge is invalid or has expired")
+		return
+	}
+
+	registered, err := utils.VerifyRegistration(body.Credential, false)
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	passkey := models.Passkey{
+		User:         user.Id,
+		Name:         body.Name,
+		CredentialId: registered.Id,
+		PublicKey:    registered.PublicKey,
+		Algorithm:    registered.Algorithm,
+		SignCount:    registered.SignCount,
+		Transports:   body.Credential.Response.Transports,
+		BackedUp:     registered.BackedUp,
+	}
+
+	if _, err := passkey.Insert(); err != nil {
+
+		if mongo.IsDuplicateKeyError(err) {
+			utils.WriteResponse(ctx, http.StatusConflict, "Passkey is already registered")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to register passkey")
+		return
+	}
+
+	authEvent(ctx, models.EventPasskeyAdded, user.Id, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusCreated, "Passkey registered", passkey)
+}
+
+func GetPasskeys(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	options := options.Find().SetSort(bson.M{"createdAt": -1})
+	cursor, err := db.Find(
+		context.Background(),
+		models.PasskeyCollection,
+		bson.M{"user": user.Id},
+		options,
+	)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve passkeys")
+		return
+	}
+
+	passkeys := []models.Passkey{}
+
+	if err := cursor.All(context.Background(), &passkeys); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve passkeys")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Passkeys retrieved", passkeys)
+}
+
+/*
+Removes a passkey. Passkey two factor is switched off with the last one so
+the account can't end up requiring a factor that no longer exists.
+*/
+func DeletePasskey(ctx *gin.Context) {
+	passkeyId, err := primitive.ObjectIDFromHex(ctx.Param("passkeyId"))
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid passkey id")
+		return
+	}
+
+	var body reauthentication
+
+	// A recent passwordless login needs no body at all
+	if err := ctx.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := loadFullUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	if !reauthenticate(ctx, user, body, models.EventPasskeyRemoved) {
+		return
+	}
+
+	if err := db.DeleteOne(
+		context.Background(),
+		models.PasskeyCollection,
+		bson.M{
+			"_id":  passkeyId,
+			"user": user.Id,
+		},
+	).Err(); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusNotFound, "Passkey not found")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to delete passkey")
+		return
+	}
+
+	remaining, err := db.Count(context.Background(), models.PasskeyCollection, bson.M{"user": user.Id})
+
+	if err == nil && remaining == 0 && user.PasskeyTwoFactor {
+		err = db.UpdateOne(
+			context.Background(),
+			models.UserCollection,
+			bson.M{"_id": user.Id},
+			bson.M{"$set": bson.M{"passkeyTwoFactor": false}},
+		).Err()
+	}
+
+	if err != nil {
+		log.Println(err)
+	}
+
+	authEvent(ctx, models.EventPasskeyRemoved, user.Id, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusOK, "Passkey deleted")
+}
+
+/*
+Turns passkeys on or off as a second factor for password, OIDC and magic
+link logins
+*/
+func SetPasskeyTwoFactor(ctx *gin.Context) {
+	var body struct {
+		reauthentication
+		Enabled bool `json:"enabled"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := loadFullUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	eventType := models.EventTwoFactorDisable
+
+	if body.Enabled {
+		eventType = models.EventTwoFactorEnable
+	}
+
+	if !reauthenticate(ctx, user, body.reauthentication, eventType) {
+		return
+	}
+
+	if body.Enabled {
+		count, err := db.Count(context.Background(), models.PasskeyCollection, bson.M{"user": user.Id})
+
+		if err != nil {
+			log.Println(err)
+			utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+
+		if count == 0 {
+			utils.WriteResponse(ctx, http.StatusBadRequest, "Register a passkey first")
+			return
+		}
+	}
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{
+			"$set": bson.M{
+				"passkeyTwoFactor": body.Enabled,
+				"updatedAt":        primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	).Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to update two factor settings")
+		return
+	}
+
+	if body.Enabled {
+		authEvent(ctx, models.EventTwoFactorEnable, user.Id, models.OutcomeSuccess, "passkey")
+		utils.WriteResponse(ctx, http.StatusOK, "Passkey two factor enabled")
+		return
+	}
+
+	authEvent(ctx, models.EventTwoFactorDisable, user.Id, models.OutcomeSuccess, "passkey")
+	utils.WriteResponse(ctx, http.StatusOK, "Passkey two factor disabled")
+}
+
+func PasskeyLoginOptions(ctx *gin.Context) {
+	challenge, err := issueToken(primitive.NilObjectID, models.TokenPasskeyAssertion, passkeyChallengeTTL)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Passkey login options", passkeyRequestOptions(challenge, nil, "required"))
+}
+
+/*
+Primary login with a passkey. User verification is required, which makes
+the passkey a second factor on its own, so no further challenge follows.
+*/
+func LoginPasskey(ctx *gin.Context) {
+	var body struct {
+		Credential utils.CredentialResponse `json:"credential" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	passkey, err := verifyPasskeyAssertion(body.Credential, primitive.NilObjectID, true)
+
+	if err != nil {
+		authEvent(ctx, models.EventPasskeyLogin, passkey.User, models.OutcomeFailure, err.Error())
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Passkey verification failed")
+		return
+	}
+
+	var user models.User
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": passkey.User},
+	).Decode(&user); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Passkey verification failed")
+		return
+	}
+
+	if !loginAllowed(ctx, user, models.EventPasskeyLogin) {
+		return
+	}
+
+	finishLogin(ctx, user, models.EventPasskeyLogin)
+}
+
+/*
+Assertion options for using a passkey as the second factor of a pending
+login challenge
+*/
+func PasskeyTwoFactorOptions(ctx *gin.Context) {
+	var body struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	loginChallenge, err := findToken(body.ChallengeToken, models.TokenLoginChallenge)
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Login challenge is invalid or has expired")
+		return
+	}
+
+	allow, err := passkeyDescriptors(loginChallenge.User)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if len(allow) == 0 {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "No passkeys registered")
+		return
+	}
+
+	challenge, err := issueToken(loginChallenge.User, models.TokenPasskeyAssertion, passkeyChallengeTTL)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Passkey login options", passkeyRequestOptions(challenge, allow, "preferred"))
+}
+
+/*
+Assertion options for confirming a sensitive change with one of the
+signed in user's passkeys
+*/
+func PasskeyReauthOptions(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	allow, err := passkeyDescriptors(user.Id)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if len(allow) == 0 {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "No passkeys registered")
+		return
+	}
+
+	challenge, err := issueToken(user.Id, models.TokenPasskeyAssertion, passkeyChallengeTTL)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Passkey options", passkeyRequestOptions(challenge, allow, "preferred"))
+}
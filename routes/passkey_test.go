@@ -0,0 +1,406 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"github.com/saheemshafi/gin-basic-api/utils/webauthntest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testPassword = "correct horse battery staple"
+
+func newTestUser(t *testing.T) models.User {
+	t.Helper()
+
+	user := models.User{
+		Name:          "Passkey Reader",
+		Email:         "reader-" + primitive.NewObjectID().Hex() + "@example.com",
+		Password:      testPassword,
+		Role:          models.DefaultRole,
+		EmailVerified: true,
+	}
+
+	if _, err := user.Insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func passkeyRouter(user models.User) *gin.Engine {
+	router := gin.New()
+
+	router.POST("/passkeys/options", signedInAs(user), PasskeyRegistrationOptions)
+	router.POST("/passkeys", signedInAs(user), RegisterPasskey)
+	router.POST("/passkeys/reauth-options", signedInAs(user), PasskeyReauthOptions)
+	router.PUT("/passkeys/two-factor", signedInAs(user), SetPasskeyTwoFactor)
+	router.DELETE("/passkeys/:passkeyId", signedInAs(user), DeletePasskey)
+	router.POST("/login", Login)
+	router.POST("/login/2fa", LoginTwoFactor)
+	router.POST("/login/2fa/passkey/options", PasskeyTwoFactorOptions)
+	router.POST("/login/passkey/options", PasskeyLoginOptions)
+	router.POST("/login/passkey", LoginPasskey)
+
+	return router
+}
+
+type publicKeyOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+	} `json:"publicKey"`
+}
+
+func challenge(t *testing.T, router *gin.Engine, path string, body any) string {
+	t.Helper()
+
+	var options publicKeyOptions
+
+	if response := call(t, router, http.MethodPost, path, body, &options); response.Status != http.StatusOK {
+		t.Fatalf("%v answered %v: %v", path, response.Status, response.Message)
+	}
+
+	return options.PublicKey.Challenge
+}
+
+/*
+Registers a new software authenticator for the user through the api. It
+reports the user id as its user handle like a discoverable credential.
+*/
+func registerAuthenticator(t *testing.T, router *gin.Engine, user models.User, algorithm int) *webauthntest.Authenticator {
+	t.Helper()
+
+	authenticator, err := webauthntest.New(algorithm)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator.UserHandle = user.Id[:]
+
+	var passkey models.Passkey
+	response := call(t, router, http.MethodPost, "/passkeys", gin.H{
+		"name":       "Laptop",
+		"password":   testPassword,
+		"credential": authenticator.Register(challenge(t, router, "/passkeys/options", nil)),
+	}, &passkey)
+
+	if response.Status != http.StatusCreated {
+		t.Fatalf("registration answered %v: %v", response.Status, response.Message)
+	}
+
+	if passkey.CredentialId != authenticator.ID() || passkey.Algorithm != algorithm {
+		t.Fatalf("stored passkey %v with algorithm %v", passkey.CredentialId, passkey.Algorithm)
+	}
+
+	return authenticator
+}
+
+func TestRegisterPasskey(t *testing.T) {
+	requireDB(t)
+
+	for name, algorithm := range map[string]int{"ES256": utils.COSEAlgES256, "EdDSA": utils.COSEAlgEdDSA} {
+		t.Run(name, func(t *testing.T) {
+			user := newTestUser(t)
+			router := passkeyRouter(user)
+			registerAuthenticator(t, router, user, algorithm)
+
+			count, err := db.Count(context.Background(), models.PasskeyCollection, bson.M{"user": user.Id})
+
+			if err != nil || count != 1 {
+				t.Errorf("user has %v passkeys, %v", count, err)
+			}
+		})
+	}
+
+	t.Run("replayed challenge", func(t *testing.T) {
+		user := newTestUser(t)
+		router := passkeyRouter(user)
+		authenticator, err := webauthntest.New(utils.COSEAlgES256)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		credential := authenticator.Register(challenge(t, router, "/passkeys/options", nil))
+		body := gin.H{"name": "Laptop", "password": testPassword, "credential": credential}
+
+		if response := call(t, router, http.MethodPost, "/passkeys", body, nil); response.Status != http.StatusCreated {
+			t.Fatalf("registration answered %v: %v", response.Status, response.Message)
+		}
+
+		if response := call(t, router, http.MethodPost, "/passkeys", body, nil); response.Status != http.StatusBadRequest {
+			t.Errorf("replayed registration answered %v", response.Status)
+		}
+	})
+
+	t.Run("no re-authentication", func(t *testing.T) {
+		user := newTestUser(t)
+		router := passkeyRouter(user)
+		authenticator, err := webauthntest.New(utils.COSEAlgES256)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		credential := authenticator.Register(challenge(t, router, "/passkeys/options", nil))
+
+		for _, body := range []gin.H{
+			{"name": "Laptop", "credential": credential},
+			{"name": "Laptop", "password": "wrong password", "credential": credential},
+		} {
+			if response := call(t, router, http.MethodPost, "/passkeys", body, nil); response.Status != http.StatusUnauthorized {
+				t.Errorf("registration answered %v", response.Status)
+			}
+		}
+	})
+
+	t.Run("challenge of another user", func(t *testing.T) {
+		owner := newTestUser(t)
+		user := newTestUser(t)
+		authenticator, err := webauthntest.New(utils.COSEAlgES256)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		credential := authenticator.Register(challenge(t, passkeyRouter(owner), "/passkeys/options", nil))
+		response := call(t, passkeyRouter(user), http.MethodPost, "/passkeys", gin.H{"name": "Laptop", "password": testPassword, "credential": credential}, nil)
+
+		if response.Status != http.StatusBadRequest {
+			t.Errorf("registration with another user's challenge answered %v", response.Status)
+		}
+	})
+}
+
+func TestLoginPasskey(t *testing.T) {
+	requireDB(t)
+
+	user := newTestUser(t)
+	router := passkeyRouter(user)
+	authenticator := registerAuthenticator(t, router, user, utils.COSEAlgES256)
+
+	login := func(credential utils.CredentialResponse) testResponse {
+		return call(t, router, http.MethodPost, "/login/passkey", gin.H{"credential": credential}, nil)
+	}
+
+	var tokens struct {
+		Token string `json:"token"`
+	}
+
+	// Usernameless, the user is found through the credential alone
+	credential := authenticator.Assert(challenge(t, router, "/login/passkey/options", nil))
+	response := call(t, router, http.MethodPost, "/login/passkey", gin.H{"credential": credential}, &tokens)
+
+	if response.Status != http.StatusOK || tokens.Token == "" {
+		t.Fatalf("passkey login answered %v: %v", response.Status, response.Message)
+	}
+
+	t.Run("replayed assertion", func(t *testing.T) {
+		if response := login(credential); response.Status != http.StatusUnauthorized {
+			t.Errorf("replayed assertion answered %v", response.Status)
+		}
+	})
+
+	t.Run("replayed challenge", func(t *testing.T) {
+		if response := login(authenticator.Assert(challengeOf(t, credential))); response.Status != http.StatusUnauthorized {
+			t.Errorf("new assertion for a used challenge answered %v", response.Status)
+		}
+	})
+
+	rejections := map[string]func(authenticator *webauthntest.Authenticator){
+		"wrong origin":         func(authenticator *webauthntest.Authenticator) { authenticator.Origin = "https://attacker.example" },
+		"wrong rp id hash":     func(authenticator *webauthntest.Authenticator) { authenticator.RPID = "attacker.example" },
+		"no user verification": func(authenticator *webauthntest.Authenticator) { authenticator.Flags = webauthntest.FlagUserPresent },
+		"sign count didn't increase": func(authenticator *webauthntest.Authenticator) {
+			authenticator.SignCount--
+			authenticator.Counterless = true
+		},
+		"other user handle": func(authenticator *webauthntest.Authenticator) {
+			other := primitive.NewObjectID()
+			authenticator.UserHandle = other[:]
+		},
+	}
+
+	for name, modify := range rejections {
+		t.Run(name, func(t *testing.T) {
+			misbehaving := *authenticator
+			modify(&misbehaving)
+
+			if response := login(misbehaving.Assert(challenge(t, router, "/login/passkey/options", nil))); response.Status != http.StatusUnauthorized {
+				t.Errorf("login answered %v", response.Status)
+			}
+		})
+	}
+
+	// None of the rejected assertions may have moved the stored counter
+	credential = authenticator.Assert(challenge(t, router, "/login/passkey/options", nil))
+
+	if response := login(credential); response.Status != http.StatusOK {
+		t.Errorf("login after the rejections answered %v: %v", response.Status, response.Message)
+	}
+}
+
+func TestLoginTwoFactorPasskey(t *testing.T) {
+	requireDB(t)
+
+	user := newTestUser(t)
+	router := passkeyRouter(user)
+	authenticator := registerAuthenticator(t, router, user, utils.COSEAlgEdDSA)
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{"$set": bson.M{"passkeyTwoFactor": true}},
+	).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	startLogin := func() string {
+		t.Helper()
+
+		var pending struct {
+			ChallengeToken string   `json:"challengeToken"`
+			Methods        []string `json:"methods"`
+		}
+
+		response := call(t, router, http.MethodPost, "/login", gin.H{"email": user.Email, "password": testPassword}, &pending)
+
+		if response.Status != http.StatusOK || pending.ChallengeToken == "" || !slices.Contains(pending.Methods, "passkey") {
+			t.Fatalf("login answered %v %v with %+v", response.Status, response.Message, pending)
+		}
+
+		return pending.ChallengeToken
+	}
+
+	// A second factor doesn't need user verification
+	authenticator.Flags = webauthntest.FlagUserPresent
+
+	t.Run("usernameless challenge", func(t *testing.T) {
+		challengeToken := startLogin()
+		credential := authenticator.Assert(challenge(t, router, "/login/passkey/options", nil))
+		response := call(t, router, http.MethodPost, "/login/2fa", gin.H{"challengeToken": challengeToken, "passkey": credential}, nil)
+
+		if response.Status != http.StatusUnauthorized {
+			t.Errorf("assertion for a challenge of no user answered %v", response.Status)
+		}
+	})
+
+	// The failed second factor counts against the account
+	skipLoginBackoff(t)
+
+	challengeToken := startLogin()
+	options := gin.H{"challengeToken": challengeToken}
+	credential := authenticator.Assert(challenge(t, router, "/login/2fa/passkey/options", options))
+
+	var tokens struct {
+		Token string `json:"token"`
+	}
+
+	response := call(t, router, http.MethodPost, "/login/2fa", gin.H{"challengeToken": challengeToken, "passkey": credential}, &tokens)
+
+	if response.Status != http.StatusOK || tokens.Token == "" {
+		t.Fatalf("second factor answered %v: %v", response.Status, response.Message)
+	}
+
+	response = call(t, router, http.MethodPost, "/login/2fa", gin.H{"challengeToken": challengeToken, "passkey": credential}, nil)
+
+	if response.Status != http.StatusUnauthorized {
+		t.Errorf("reused login challenge answered %v", response.Status)
+	}
+}
+
+func TestSetPasskeyTwoFactorReauthentication(t *testing.T) {
+	requireDB(t)
+
+	user := newTestUser(t)
+	router := passkeyRouter(user)
+	authenticator := registerAuthenticator(t, router, user, utils.COSEAlgES256)
+
+	rejected := map[string]gin.H{
+		"no proof":       {"enabled": true},
+		"wrong password": {"enabled": true, "password": "wrong password"},
+		"totp disabled":  {"enabled": true, "code": "123456"},
+	}
+
+	for name, body := range rejected {
+		t.Run(name, func(t *testing.T) {
+			if response := call(t, router, http.MethodPut, "/passkeys/two-factor", body, nil); response.Status != http.StatusUnauthorized {
+				t.Errorf("answered %v", response.Status)
+			}
+		})
+	}
+
+	credential := authenticator.Assert(challenge(t, router, "/passkeys/reauth-options", nil))
+	response := call(t, router, http.MethodPut, "/passkeys/two-factor", gin.H{"enabled": true, "passkey": credential}, nil)
+
+	if response.Status != http.StatusOK {
+		t.Fatalf("passkey re-authentication answered %v: %v", response.Status, response.Message)
+	}
+
+	if user, err := findUser(user.Id); err != nil || !user.PasskeyTwoFactor {
+		t.Errorf("passkey two factor wasn't enabled: %v", err)
+	}
+}
+
+func TestDeletePasskeyReauthentication(t *testing.T) {
+	requireDB(t)
+
+	user := newTestUser(t)
+	router := passkeyRouter(user)
+	authenticator := registerAuthenticator(t, router, user, utils.COSEAlgES256)
+
+	var passkey models.Passkey
+
+	if err := db.FindOne(context.Background(), models.PasskeyCollection, bson.M{"user": user.Id}).Decode(&passkey); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/passkeys/" + passkey.Id.Hex()
+
+	for name, body := range map[string]any{"no proof": nil, "wrong password": gin.H{"password": "wrong password"}} {
+		t.Run(name, func(t *testing.T) {
+			if response := call(t, router, http.MethodDelete, path, body, nil); response.Status != http.StatusUnauthorized {
+				t.Errorf("answered %v", response.Status)
+			}
+		})
+	}
+
+	credential := authenticator.Assert(challenge(t, router, "/passkeys/reauth-options", nil))
+
+	if response := call(t, router, http.MethodDelete, path, gin.H{"passkey": credential}, nil); response.Status != http.StatusOK {
+		t.Fatalf("passkey re-authentication answered %v: %v", response.Status, response.Message)
+	}
+
+	if count, err := db.Count(context.Background(), models.PasskeyCollection, bson.M{"user": user.Id}); err != nil || count != 0 {
+		t.Errorf("user still has %v passkeys, %v", count, err)
+	}
+}
+
+func findUser(userId primitive.ObjectID) (models.User, error) {
+	var user models.User
+
+	err := db.FindOne(context.Background(), models.UserCollection, bson.M{"_id": userId}).Decode(&user)
+
+	return user, err
+}
+
+func challengeOf(t *testing.T, credential utils.CredentialResponse) string {
+	t.Helper()
+
+	clientData, err := utils.ParseClientData(credential.Response.ClientDataJSON, utils.WebAuthnGet)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return clientData.Challenge
+}
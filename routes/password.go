@@ -0,0 +1,247 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const passwordResetTTL = time.Hour
+
+func sendPasswordResetEmail(user models.User) error {
+	if err := discardTokens(user.Id, models.TokenPasswordReset); err != nil {
+		return err
+	}
+
+	token, err := issueToken(user.Id, models.TokenPasswordReset, passwordResetTTL)
+
+	if err != nil {
+		return err
+	}
+
+	return utils.SendMail(
+		user.Email,
+		"Reset your password",
+		fmt.Sprintf(
+			"Hi %v,\n\nUse the link below to reset your password. It expires in %v.\n\n%v\n\nIf you didn't ask for this you can ignore this email.",
+			user.Name,
+			passwordResetTTL,
+			utils.AppURL("/reset-password?token="+token),
+		),
+	)
+}
+
+func ForgotPassword(ctx *gin.Context) {
+	var body struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Same answer whether or not the account exists so emails can't be
+	// enumerated through this endpoint.
+	const message = "If an account exists for this email a reset link has been sent"
+
+	var user models.User
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"email": models.NormalizeEmail(body.Email)},
+	).Decode(&user); err != nil {
+
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+		}
+
+		utils.WriteResponse(ctx, http.StatusOK, message)
+		return
+	}
+
+	if err := sendPasswordResetEmail(user); err != nil {
+		log.Println(err)
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, message)
+}
+
+func ResetPassword(ctx *gin.Context) {
+	var body struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The token is only burnt once the new password is acceptable
+	token, err := findToken(body.Token, models.TokenPasswordReset)
+
+	if err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusBadRequest, "Reset link is invalid or has expired")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	var user models.User
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": token.User},
+	).Decode(&user); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Reset link is invalid or has expired")
+		return
+	}
+
+	if errs := utils.ValidatePassword("password", body.Password, user.Name, user.Email); errs != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Password doesn't meet the requirements", errs)
+		return
+	}
+
+	if _, err := consumeToken(body.Token, models.TokenPasswordReset); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Reset link is invalid or has expired")
+		return
+	}
+
+	hash, err := utils.HashPassword(body.Password)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": token.User},
+		bson.M{
+			"$set": bson.M{
+				"password":              hash,
+				"passwordResetRequired": false,
+				"updatedAt":             primitive.NewDateTimeFromTime(time.Now()),
+			},
+			"$inc": bson.M{
+				"tokenVersion": 1,
+			},
+		},
+	)
+
+	if err := result.Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	if err := revokeAllSessions(token.User); err != nil {
+		log.Println(err)
+	}
+
+	authEvent(ctx, models.EventPasswordReset, token.User, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusOK, "Password has been reset")
+}
+
+func ChangePassword(ctx *gin.Context) {
+	var body struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := loadFullUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	if !utils.ComparePasswordHashes(body.CurrentPassword, user.Password) {
+		authEvent(ctx, models.EventPasswordChange, user.Id, models.OutcomeFailure, "incorrect password")
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Current password is incorrect")
+		return
+	}
+
+	if errs := utils.ValidatePassword("newPassword", body.NewPassword, user.Name, user.Email); errs != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Password doesn't meet the requirements", errs)
+		return
+	}
+
+	hash, err := utils.HashPassword(body.NewPassword)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{
+			"$set": bson.M{
+				"password":  hash,
+				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+			},
+			"$inc": bson.M{
+				"tokenVersion": 1,
+			},
+		},
+		options,
+	)
+
+	if err := result.Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	result.Decode(&user)
+
+	authEvent(ctx, models.EventPasswordChange, user.Id, models.OutcomeSuccess, "")
+
+	// Every other device is signed out, this one gets a fresh session
+	if err := revokeAllSessions(user.Id); err != nil {
+		log.Println(err)
+	}
+
+	tokens, err := startSession(ctx, user, models.EventPasswordChange)
+
+	if err != nil {
+		log.Println(err)
+		clearSessionCookies(ctx)
+		utils.WriteResponse(ctx, http.StatusOK, "Password changed, please login again")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Password changed", tokens)
+}
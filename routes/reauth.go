@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// How long after a passwordless login it still counts as re-authentication
+const reauthWindow = 5 * time.Minute
+
+/*
+Proof of identity asked for before sensitive account changes. Accounts
+created through a provider never learn their random password, so a TOTP
+code or a passkey assertion works too, and so does a session that signed
+in without a password moments ago.
+*/
+type reauthentication struct {
+	Password string                    `json:"password"`
+	Code     string                    `json:"code"`
+	Passkey  *utils.CredentialResponse `json:"passkey"`
+}
+
+/*
+Fetches the signed in user again with the password hash, which Authorize
+strips from the context user. Writes the error response when it fails.
+*/
+func loadFullUser(ctx *gin.Context) (models.User, bool) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+	).Decode(&user); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return user, false
+	}
+
+	return user, true
+}
+
+/*
+Checks the proof against the user, which has to include the password hash.
+Writes the error response and records a failed event of eventType when the
+proof doesn't hold.
+*/
+func reauthenticate(ctx *gin.Context, user models.User, proof reauthentication, eventType models.AuthEventType) bool {
+	var reason, message string
+
+	switch {
+	case proof.Password != "":
+		if utils.ComparePasswordHashes(proof.Password, user.Password) {
+			return true
+		}
+
+		reason, message = "incorrect password", "Password is incorrect"
+	case proof.Code != "":
+		if user.TOTPEnabled && verifySecondFactor(user, proof.Code, "") {
+			return true
+		}
+
+		reason, message = "invalid two factor code", "Invalid two factor code"
+	case proof.Passkey != nil:
+		_, err := verifyPasskeyAssertion(*proof.Passkey, user.Id, false)
+
+		if err == nil {
+			return true
+		}
+
+		reason, message = err.Error(), "Passkey verification failed"
+	default:
+		if recentPasswordlessLogin(ctx) {
+			return true
+		}
+
+		reason, message = "missing re-authentication", "Confirm it's you with your password, a two factor code, a passkey or by signing in again"
+	}
+
+	authEvent(ctx, eventType, user.Id, models.OutcomeFailure, reason)
+	utils.WriteResponse(ctx, http.StatusUnauthorized, message)
+	return false
+}
+
+/*
+Reports whether the current session was opened through a provider, magic
+link or passkey within the re-authentication window
+*/
+func recentPasswordlessLogin(ctx *gin.Context) bool {
+	sessionFromCtx, exists := ctx.Get("session")
+
+	if !exists {
+		return false
+	}
+
+	session := sessionFromCtx.(models.Session)
+
+	if session.Impersonator != nil || time.Since(session.CreatedAt.Time()) > reauthWindow {
+		return false
+	}
+
+	switch session.LoginMethod {
+	case models.EventOIDCLogin, models.EventMagicLinkLogin, models.EventPasskeyLogin:
+		return true
+	}
+
+	return false
+}
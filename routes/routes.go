@@ -3,29 +3,91 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/saheemshafi/gin-basic-api/middlewares"
+	"github.com/saheemshafi/gin-basic-api/models"
 )
 
 func Register(app *gin.Engine) {
 
+	app.GET("/.well-known/jwks.json", GetJWKS)
+
 	v1 := app.Group("/api/v1")
 
 	// User routes
 	users := v1.Group("/users")
 	users.POST("/create-account", CreateAccount)
 	users.POST("/login", Login)
+	users.POST("/login/2fa", LoginTwoFactor)
+	users.POST("/login/2fa/passkey/options", PasskeyTwoFactorOptions)
+	users.POST("/login/passkey/options", PasskeyLoginOptions)
+	users.POST("/login/passkey", LoginPasskey)
+	users.POST("/magic-link", RequestMagicLink)
+	users.GET("/magic-link/consume", ConsumeMagicLink)
+	users.GET("/oidc/:provider", OIDCLogin)
+	users.GET("/oidc/:provider/callback", OIDCCallback)
+	users.POST("/refresh", Refresh)
+	users.POST("/logout", Logout)
+	users.POST("/forgot-password", ForgotPassword)
+	users.POST("/reset-password", ResetPassword)
+	users.GET("/verify-email", VerifyEmail)
+	users.POST("/verify-email/resend", ResendVerification)
+	users.GET("/email/confirm", ConfirmEmailChange)
 	users.PUT("/", middlewares.Authorize, UpdateUser)
+	users.GET("/me", middlewares.Authorize, GetMe)
+	users.DELETE("/me", middlewares.Authorize, middlewares.BlockImpersonation, DeleteMe)
+	users.GET("/me/export", middlewares.Authorize, ExportMe)
+	users.PUT("/me/avatar", middlewares.Authorize, ChangeAvatar)
+	users.DELETE("/me/avatar", middlewares.Authorize, DeleteAvatar)
+	users.GET("/me/security-events", middlewares.Authorize, GetSecurityEvents)
+	users.GET("/:userId", GetProfile)
+	users.PUT("/password", middlewares.Authorize, middlewares.BlockImpersonation, ChangePassword)
+	users.PUT("/email", middlewares.Authorize, middlewares.BlockImpersonation, ChangeEmail)
+	users.POST("/2fa/setup", middlewares.Authorize, middlewares.BlockImpersonation, SetupTwoFactor)
+	users.POST("/2fa/confirm", middlewares.Authorize, middlewares.BlockImpersonation, ConfirmTwoFactor)
+	users.DELETE("/2fa", middlewares.Authorize, middlewares.BlockImpersonation, DisableTwoFactor)
+	users.POST("/passkeys/options", middlewares.Authorize, middlewares.BlockImpersonation, PasskeyRegistrationOptions)
+	users.POST("/passkeys", middlewares.Authorize, middlewares.BlockImpersonation, RegisterPasskey)
+	users.GET("/passkeys", middlewares.Authorize, GetPasskeys)
+	users.POST("/passkeys/reauth-options", middlewares.Authorize, middlewares.BlockImpersonation, PasskeyReauthOptions)
+	users.PUT("/passkeys/two-factor", middlewares.Authorize, middlewares.BlockImpersonation, SetPasskeyTwoFactor)
+	users.DELETE("/passkeys/:passkeyId", middlewares.Authorize, middlewares.BlockImpersonation, DeletePasskey)
+	users.POST("/api-keys", middlewares.Authorize, middlewares.BlockImpersonation, CreateAPIKey)
+	users.GET("/api-keys", middlewares.Authorize, GetAPIKeys)
+	users.PATCH("/api-keys/:keyId", middlewares.Authorize, middlewares.BlockImpersonation, UpdateAPIKey)
+	users.DELETE("/api-keys/:keyId", middlewares.Authorize, DeleteAPIKey)
+	users.POST("/invites", middlewares.Authorize, middlewares.BlockImpersonation, CreateInvite)
+	users.GET("/invites", middlewares.Authorize, GetInvites)
+	users.DELETE("/invites/:inviteId", middlewares.Authorize, RevokeInvite)
+	users.GET("/sessions", middlewares.Authorize, GetSessions)
+	users.DELETE("/sessions/:sessionId", middlewares.Authorize, RevokeSession)
+
+	// Admin routes
+	admin := v1.Group("/admin", middlewares.Authorize, middlewares.RequireRole(models.RoleAdmin))
+	admin.GET("/users", ListUsers)
+	admin.GET("/users/:userId", GetUser)
+	admin.GET("/users/:userId/books", GetUserBooks)
+	admin.POST("/users/:userId/suspend", SuspendUser)
+	admin.POST("/users/:userId/unsuspend", UnsuspendUser)
+	admin.POST("/users/:userId/ban", BanUser)
+	admin.PUT("/users/:userId/role", ChangeUserRole)
+	admin.POST("/users/:userId/force-password-reset", ForcePasswordReset)
+	admin.POST("/users/:userId/unlock", UnlockUser)
+	admin.POST("/users/:userId/impersonate", ImpersonateUser)
+	admin.GET("/invites", ListInvites)
+	admin.DELETE("/invites/:inviteId", AdminRevokeInvite)
+	admin.GET("/audit-logs", GetAuditLogs)
+	admin.GET("/auth-events", GetAuthEvents)
 
 	// Book routes
 	books := v1.Group("/books")
-	books.GET("/", GetBooks)
-	books.GET("/:bookId", GetBook)
-	books.POST("/", middlewares.Authorize, CreateBook)
-	books.PUT("/:bookId", middlewares.Authorize, UpdateBook)
-	books.DELETE("/:bookId", middlewares.Authorize, DeleteBook)
-	books.POST("/:bookId/pages", middlewares.Authorize, AddPage)
-	books.PUT("/:bookId/pages/:pageId", middlewares.Authorize, UpdatePage)
-	books.DELETE("/:bookId/pages/:pageId", middlewares.Authorize, DeletePage)
-
-	books.PUT("/:bookId/cover", middlewares.Authorize, ChangeBookCover)
-	books.PUT("/:bookId/pages/:pageId/cover", middlewares.Authorize, ChangePageCover)
+	books.GET("/", middlewares.APIKeyScope(models.ScopeBooksRead), GetBooks)
+	books.GET("/:bookId", middlewares.APIKeyScope(models.ScopeBooksRead), GetBook)
+	books.POST("/", middlewares.AuthorizeScope(models.ScopeBooksWrite), middlewares.RequirePermission(models.PermissionCreateBooks), middlewares.RequireVerifiedEmail, CreateBook)
+	books.PUT("/:bookId", middlewares.AuthorizeScope(models.ScopeBooksWrite), UpdateBook)
+	books.DELETE("/:bookId", middlewares.AuthorizeScope(models.ScopeBooksWrite), DeleteBook)
+	books.POST("/:bookId/pages", middlewares.AuthorizeScope(models.ScopePagesWrite), AddPage)
+	books.PUT("/:bookId/pages/:pageId", middlewares.AuthorizeScope(models.ScopePagesWrite), UpdatePage)
+	books.DELETE("/:bookId/pages/:pageId", middlewares.AuthorizeScope(models.ScopePagesWrite), DeletePage)
+
+	books.PUT("/:bookId/cover", middlewares.AuthorizeScope(models.ScopeBooksWrite), ChangeBookCover)
+	books.PUT("/:bookId/pages/:pageId/cover", middlewares.AuthorizeScope(models.ScopePagesWrite), ChangePageCover)
 }
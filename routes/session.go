@@ -0,0 +1,315 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/middlewares"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Refresh and logout are the only routes reading the refresh cookie
+const refreshCookiePath = "/api/v1/users"
+
+/*
+Starts a new refresh token family for the user and sets the session
+cookies. The CSRF token lives as long as the session and isn't rotated on
+refresh.
+*/
+func startSession(ctx *gin.Context, user models.User, method models.AuthEventType) (gin.H, error) {
+	refreshToken, refreshErr := utils.GenerateToken(32)
+	csrfToken, csrfErr := utils.GenerateToken(32)
+
+	if err := errors.Join(refreshErr, csrfErr); err != nil {
+		return nil, err
+	}
+
+	session := models.Session{
+		User:         user.Id,
+		RefreshToken: utils.HashToken(refreshToken),
+		CSRFToken:    utils.HashToken(csrfToken),
+		UserAgent:    ctx.Request.UserAgent(),
+		IP:           ctx.ClientIP(),
+		TokenVersion: user.TokenVersion,
+		LoginMethod:  method,
+	}
+
+	if _, err := session.Insert(); err != nil {
+		return nil, err
+	}
+
+	tokens, err := issueTokens(ctx, session, refreshToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	setCSRFCookie(ctx, session, csrfToken)
+	tokens["csrfToken"] = csrfToken
+
+	return tokens, nil
+}
+
+// Readable by scripts so the client can echo it in the CSRF header
+func setCSRFCookie(ctx *gin.Context, session models.Session, csrfToken string) {
+	utils.SetCookie(
+		ctx,
+		utils.GetCookieConfig().CSRFName,
+		csrfToken,
+		int(time.Until(session.ExpiresAt.Time()).Seconds()),
+		"/",
+		false,
+	)
+}
+
+/*
+Sessions started before CSRF tokens existed don't have one, so cookie
+authenticated writes fail for them until the next refresh hands one out.
+Only fills the token in if no concurrent refresh did it first.
+*/
+func upgradeLegacySession(ctx *gin.Context, session models.Session) (string, error) {
+	csrfToken, err := utils.GenerateToken(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.SessionCollection,
+		bson.M{
+			"_id":       session.Id,
+			"csrfToken": bson.M{"$in": bson.A{nil, ""}},
+		},
+		bson.M{
+			"$set": bson.M{
+				"csrfToken": utils.HashToken(csrfToken),
+				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	)
+
+	if err := result.Err(); err != nil {
+		return "", err
+	}
+
+	setCSRFCookie(ctx, session, csrfToken)
+
+	return csrfToken, nil
+}
+
+/*
+Signs a fresh access token for the session and writes the cookies
+*/
+func issueTokens(ctx *gin.Context, session models.Session, refreshToken string) (gin.H, error) {
+	accessExpiry := time.Now().Add(utils.AccessTokenTTL)
+	token, err := utils.EncodeJWT(session.User.Hex(), session.Id.Hex(), session.TokenVersion, accessExpiry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := utils.GetCookieConfig()
+
+	utils.SetCookie(
+		ctx,
+		config.SessionName,
+		token,
+		int(time.Until(accessExpiry).Seconds()),
+		config.Path,
+		true,
+	)
+
+	utils.SetCookie(
+		ctx,
+		config.RefreshName,
+		refreshToken,
+		int(time.Until(session.ExpiresAt.Time()).Seconds()),
+		refreshCookiePath,
+		true,
+	)
+
+	return gin.H{
+		"token":        token,
+		"refreshToken": refreshToken,
+	}, nil
+}
+
+func clearSessionCookies(ctx *gin.Context) {
+	config := utils.GetCookieConfig()
+
+	utils.SetCookie(ctx, config.SessionName, "", -1, config.Path, true)
+	utils.SetCookie(ctx, config.RefreshName, "", -1, refreshCookiePath, true)
+	utils.SetCookie(ctx, config.CSRFName, "", -1, "/", false)
+}
+
+/*
+Reads the refresh token from its cookie, falling back to the request body
+for clients that don't keep cookies. Also reports whether it came from the
+cookie, those requests need a CSRF token.
+*/
+func readRefreshToken(ctx *gin.Context) (string, bool) {
+	if cookie, err := ctx.Cookie(utils.GetCookieConfig().RefreshName); err == nil && cookie != "" {
+		return cookie, true
+	}
+
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	ctx.ShouldBindJSON(&body)
+
+	return body.RefreshToken, false
+}
+
+/*
+Checks the CSRF header against the session the refresh token belongs to.
+Unknown tokens pass so refresh can still detect reuse of rotated ones, and
+legacy sessions without a CSRF token pass so they can refresh into one or
+logout.
+*/
+func refreshCSRFValid(ctx *gin.Context, refreshToken string) bool {
+	var session models.Session
+
+	if err := db.FindOne(
+		context.Background(),
+		models.SessionCollection,
+		bson.M{"refreshToken": utils.HashToken(refreshToken)},
+	).Decode(&session); err != nil || session.CSRFToken == "" {
+		return true
+	}
+
+	return middlewares.ValidCSRF(ctx, session)
+}
+
+func GetSessions(ctx *gin.Context) {
+
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	sessionFromCtx, _ := ctx.Get("session")
+	current := sessionFromCtx.(models.Session)
+
+	options := options.Find().SetSort(bson.M{"lastSeenAt": -1})
+	cursor, err := db.Find(
+		context.Background(),
+		models.SessionCollection,
+		bson.M{
+			"user":      user.Id,
+			"revoked":   false,
+			"expiresAt": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+		},
+		options,
+	)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve sessions")
+		return
+	}
+
+	var sessions []models.Session
+
+	if err := cursor.All(context.Background(), &sessions); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve sessions")
+		return
+	}
+
+	response := make([]gin.H, 0, len(sessions))
+
+	for _, session := range sessions {
+		response = append(response, gin.H{
+			"_id":          session.Id,
+			"userAgent":    session.UserAgent,
+			"ip":           session.IP,
+			"current":      session.Id == current.Id,
+			"impersonated": session.Impersonator != nil,
+			"lastSeenAt":   session.LastSeenAt,
+			"createdAt":    session.CreatedAt,
+		})
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Sessions retrieved", response)
+}
+
+func RevokeSession(ctx *gin.Context) {
+	sessionId, err := primitive.ObjectIDFromHex(ctx.Param("sessionId"))
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid session id")
+		return
+	}
+
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.SessionCollection,
+		bson.M{
+			"_id":     sessionId,
+			"user":    user.Id,
+			"revoked": false,
+		},
+		bson.M{
+			"$set": bson.M{
+				"revoked":   true,
+				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	)
+
+	if err := result.Err(); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusNotFound, "Session not found")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	sessionFromCtx, _ := ctx.Get("session")
+
+	if sessionFromCtx.(models.Session).Id == sessionId {
+		clearSessionCookies(ctx)
+	}
+
+	authEvent(ctx, models.EventSessionRevoked, user.Id, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusOK, "Session revoked")
+}
+
+/*
+Revokes every session of the user, signing them out on all devices
+*/
+func revokeAllSessions(userId primitive.ObjectID) error {
+	_, err := db.UpdateMany(
+		context.Background(),
+		models.SessionCollection,
+		bson.M{
+			"user":    userId,
+			"revoked": false,
+		},
+		bson.M{
+			"$set": bson.M{
+				"revoked":   true,
+				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	)
+
+	return err
+}
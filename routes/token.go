@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"context"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+Creates a single use token for the user and returns the raw value, which
+is only ever sent to the user
+*/
+func issueToken(userId primitive.ObjectID, purpose models.TokenPurpose, ttl time.Duration) (string, error) {
+	raw, err := utils.GenerateToken(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	token := models.Token{
+		User:      userId,
+		Purpose:   purpose,
+		Hash:      utils.HashToken(raw),
+		ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(ttl)),
+	}
+
+	if _, err := token.Insert(); err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+/*
+Returns a valid token without using it up
+*/
+func findToken(raw string, purpose models.TokenPurpose) (models.Token, error) {
+	var token models.Token
+
+	err := db.FindOne(
+		context.Background(),
+		models.TokenCollection,
+		bson.M{
+			"hash":      utils.HashToken(raw),
+			"purpose":   purpose,
+			"used":      false,
+			"expiresAt": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+		},
+	).Decode(&token)
+
+	return token, err
+}
+
+/*
+Atomically marks a valid token as used and returns it. Fails with
+mongo.ErrNoDocuments when the token is unknown, expired or already used.
+*/
+func consumeToken(raw string, purpose models.TokenPurpose) (models.Token, error) {
+	var token models.Token
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.TokenCollection,
+		bson.M{
+			"hash":      utils.HashToken(raw),
+			"purpose":   purpose,
+			"used":      false,
+			"expiresAt": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+		},
+		bson.M{
+			"$set": bson.M{"used": true},
+		},
+	)
+
+	if err := result.Err(); err != nil {
+		return token, err
+	}
+
+	err := result.Decode(&token)
+	return token, err
+}
+
+/*
+Burns every outstanding token of a purpose for the user
+*/
+func discardTokens(userId primitive.ObjectID, purpose models.TokenPurpose) error {
+	_, err := db.UpdateMany(
+		context.Background(),
+		models.TokenCollection,
+		bson.M{
+			"user":    userId,
+			"purpose": purpose,
+			"used":    false,
+		},
+		bson.M{
+			"$set": bson.M{"used": true},
+		},
+	)
+
+	return err
+}
@@ -0,0 +1,436 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	loginChallengeTTL      = 5 * time.Minute
+	loginChallengeAttempts = 5
+	recoveryCodeCount      = 10
+)
+
+/*
+Finishes a first factor login. Users with two factor enabled get a challenge
+token to exchange at /login/2fa, everyone else gets a session straight away.
+*/
+func completeLogin(ctx *gin.Context, user models.User, eventType models.AuthEventType) {
+	if !loginAllowed(ctx, user, eventType) {
+		return
+	}
+
+	methods := []string{}
+
+	if user.TOTPEnabled {
+		methods = append(methods, "totp", "recovery-code")
+	}
+
+	if user.PasskeyTwoFactor {
+		methods = append(methods, "passkey")
+	}
+
+	if len(methods) > 0 {
+		challenge, err := issueToken(user.Id, models.TokenLoginChallenge, loginChallengeTTL)
+
+		if err != nil {
+			log.Println(err)
+			utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to login")
+			return
+		}
+
+		authEvent(ctx, eventType, user.Id, models.OutcomeSuccess, "second factor required")
+
+		utils.WriteResponse(ctx, http.StatusOK, "Two factor code required", gin.H{
+			"twoFactorRequired": true,
+			"challengeToken":    challenge,
+			"methods":           methods,
+		})
+		return
+	}
+
+	finishLogin(ctx, user, eventType)
+}
+
+/*
+Starts the session once every required factor was checked. Failed attempts
+against the account are only forgiven at this point, so a known password
+doesn't reset the count while the second factor is being guessed.
+*/
+func finishLogin(ctx *gin.Context, user models.User, eventType models.AuthEventType) {
+	tokens, err := startSession(ctx, user, eventType)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+
+	if err := clearLoginFailures(accountAttemptKey(user.Email)); err != nil {
+		log.Println(err)
+	}
+
+	authEvent(ctx, eventType, user.Id, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusOK, "Logged in", tokens)
+}
+
+/*
+Refuses sessions for suspended or banned accounts and for accounts an admin
+sent through a password reset
+*/
+func loginAllowed(ctx *gin.Context, user models.User, eventType models.AuthEventType) bool {
+	if !user.Active() {
+		authEvent(ctx, eventType, user.Id, models.OutcomeFailure, fmt.Sprintf("account %v", user.Status))
+		utils.WriteResponse(ctx, http.StatusForbidden, fmt.Sprintf("Account is %v", user.Status))
+		return false
+	}
+
+	if user.PasswordResetRequired {
+		authEvent(ctx, eventType, user.Id, models.OutcomeFailure, "password reset required")
+		utils.WriteResponse(ctx, http.StatusForbidden, "Reset your password using the link sent to your email")
+		return false
+	}
+
+	return true
+}
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+
+	return "Gin Basic Api"
+}
+
+func SetupTwoFactor(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	if user.TOTPEnabled {
+		utils.WriteResponse(ctx, http.StatusConflict, "Two factor authentication is already enabled")
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{
+			"$set": bson.M{
+				"totpSecret": secret,
+				"updatedAt":  primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	)
+
+	if err := result.Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Scan the code and confirm it with a code from your app", gin.H{
+		"secret": secret,
+		"uri":    utils.TOTPURI(totpIssuer(), user.Email, secret),
+	})
+}
+
+func ConfirmTwoFactor(ctx *gin.Context) {
+	var body struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	if user.TOTPEnabled {
+		utils.WriteResponse(ctx, http.StatusConflict, "Two factor authentication is already enabled")
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Start two factor setup first")
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, body.Code, time.Now())
+
+	if !ok {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{
+			"$set": bson.M{
+				"totpEnabled":   true,
+				"totpLastStep":  step,
+				"recoveryCodes": hashes,
+				"updatedAt":     primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	)
+
+	if err := result.Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to enable two factor authentication")
+		return
+	}
+
+	authEvent(ctx, models.EventTwoFactorEnable, user.Id, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusOK, "Two factor authentication enabled, store these recovery codes safely", codes)
+}
+
+func DisableTwoFactor(ctx *gin.Context) {
+	var body reauthentication
+
+	if err := ctx.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := loadFullUser(ctx)
+
+	if !ok {
+		return
+	}
+
+	if !reauthenticate(ctx, user, body, models.EventTwoFactorDisable) {
+		return
+	}
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{
+			"$set": bson.M{
+				"totpEnabled":   false,
+				"totpSecret":    "",
+				"totpLastStep":  0,
+				"recoveryCodes": []string{},
+				"updatedAt":     primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	)
+
+	if err := result.Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to disable two factor authentication")
+		return
+	}
+
+	authEvent(ctx, models.EventTwoFactorDisable, user.Id, models.OutcomeSuccess, "")
+	utils.WriteResponse(ctx, http.StatusOK, "Two factor authentication disabled")
+}
+
+/*
+Second step of Login. Exchanges the challenge token and a TOTP or recovery
+code for a session.
+*/
+func LoginTwoFactor(ctx *gin.Context) {
+	var body struct {
+		ChallengeToken string                    `json:"challengeToken" binding:"required"`
+		Code           string                    `json:"code"`
+		RecoveryCode   string                    `json:"recoveryCode"`
+		Passkey        *utils.CredentialResponse `json:"passkey"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if body.Code == "" && body.RecoveryCode == "" && body.Passkey == nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Provide a code, a recovery code or a passkey")
+		return
+	}
+
+	var challenge models.Token
+
+	if err := db.FindOne(
+		context.Background(),
+		models.TokenCollection,
+		bson.M{
+			"hash":      utils.HashToken(body.ChallengeToken),
+			"purpose":   models.TokenLoginChallenge,
+			"used":      false,
+			"attempts":  bson.M{"$lt": loginChallengeAttempts},
+			"expiresAt": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+		},
+	).Decode(&challenge); err != nil {
+
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+		}
+
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Login challenge is invalid or has expired")
+		return
+	}
+
+	var user models.User
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": challenge.User},
+	).Decode(&user); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Login challenge is invalid or has expired")
+		return
+	}
+
+	if !loginAllowed(ctx, user, models.EventLoginTwoFactor) {
+		return
+	}
+
+	accountKey := accountAttemptKey(user.Email)
+	ipKey := ipAttemptKey(ctx.ClientIP())
+
+	if wait := loginRetryAfter(accountKey, ipKey); wait > 0 {
+		authEvent(ctx, models.EventLoginTwoFactor, user.Id, models.OutcomeFailure, "locked out")
+		writeLockedOut(ctx, wait)
+		return
+	}
+
+	verified := false
+	reason := "invalid code"
+
+	if body.Passkey != nil {
+		if user.PasskeyTwoFactor {
+			_, err := verifyPasskeyAssertion(*body.Passkey, user.Id, false)
+			verified = err == nil
+
+			if err != nil {
+				reason = err.Error()
+			}
+		}
+	} else if user.TOTPEnabled {
+		verified = verifySecondFactor(user, body.Code, body.RecoveryCode)
+	}
+
+	if !verified {
+		db.UpdateOne(
+			context.Background(),
+			models.TokenCollection,
+			bson.M{"_id": challenge.Id},
+			bson.M{"$inc": bson.M{"attempts": 1}},
+		)
+
+		// Challenges are cheap to get again with the password, the lockout
+		// is what bounds guessing
+		recordLoginFailure(accountKey, envInt("LOGIN_MAX_ATTEMPTS", 5))
+		recordLoginFailure(ipKey, envInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20))
+		authEvent(ctx, models.EventLoginTwoFactor, user.Id, models.OutcomeFailure, reason)
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Invalid second factor")
+		return
+	}
+
+	if _, err := consumeToken(body.ChallengeToken, models.TokenLoginChallenge); err != nil {
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Login challenge is invalid or has expired")
+		return
+	}
+
+	finishLogin(ctx, user, models.EventLoginTwoFactor)
+}
+
+/*
+Checks a TOTP code, refusing steps that were already used, or burns a
+recovery code. Both updates are conditional so concurrent requests can't
+reuse the same code.
+*/
+func verifySecondFactor(user models.User, code string, recoveryCode string) bool {
+	if code != "" {
+		step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+
+		if !ok || step <= user.TOTPLastStep {
+			return false
+		}
+
+		result := db.UpdateOne(
+			context.Background(),
+			models.UserCollection,
+			bson.M{
+				"_id":          user.Id,
+				"totpLastStep": bson.M{"$lt": step},
+			},
+			bson.M{
+				"$set": bson.M{"totpLastStep": step},
+			},
+		)
+
+		return result.Err() == nil
+	}
+
+	hash := utils.HashToken(utils.NormalizeRecoveryCode(recoveryCode))
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{
+			"_id":           user.Id,
+			"recoveryCodes": hash,
+		},
+		bson.M{
+			"$pull": bson.M{"recoveryCodes": hash},
+		},
+	)
+
+	return result.Err() == nil
+}
+
+/*
+Returns fresh recovery codes for the user along with the hashes to store
+*/
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(utils.NormalizeRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
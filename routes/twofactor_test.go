@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func accountFailures(t *testing.T, user models.User) int {
+	t.Helper()
+
+	var attempt models.LoginAttempt
+
+	if err := db.FindOne(
+		context.Background(),
+		models.LoginAttemptCollection,
+		bson.M{"key": accountAttemptKey(user.Email)},
+	).Decode(&attempt); err != nil {
+		return 0
+	}
+
+	return attempt.Failures
+}
+
+// Lets the next attempt through without waiting out the backoff
+func skipLoginBackoff(t *testing.T) {
+	t.Helper()
+
+	if _, err := db.UpdateMany(
+		context.Background(),
+		models.LoginAttemptCollection,
+		bson.M{},
+		bson.M{"$set": bson.M{"nextAttemptAt": primitive.NewDateTimeFromTime(time.Now().Add(-time.Second))}},
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginTwoFactorLockout(t *testing.T) {
+	requireDB(t)
+
+	user := newTestUser(t)
+	recoveryCode := "abcde-fghij"
+	secret, err := utils.GenerateTOTPSecret()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": user.Id},
+		bson.M{"$set": bson.M{
+			"totpEnabled":   true,
+			"totpSecret":    secret,
+			"recoveryCodes": []string{utils.HashToken(utils.NormalizeRecoveryCode(recoveryCode))},
+		}},
+	).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Earlier tests may have failed from the same address
+	skipLoginBackoff(t)
+
+	router := gin.New()
+	router.POST("/login", Login)
+	router.POST("/login/2fa", LoginTwoFactor)
+
+	startLogin := func() string {
+		t.Helper()
+
+		var pending struct {
+			ChallengeToken string `json:"challengeToken"`
+		}
+
+		response := call(t, router, http.MethodPost, "/login", gin.H{"email": user.Email, "password": testPassword}, &pending)
+
+		if response.Status != http.StatusOK || pending.ChallengeToken == "" {
+			t.Fatalf("login answered %v: %v", response.Status, response.Message)
+		}
+
+		return pending.ChallengeToken
+	}
+
+	challengeToken := startLogin()
+	wrongCode := gin.H{"challengeToken": challengeToken, "recoveryCode": "wrong-code"}
+
+	if response := call(t, router, http.MethodPost, "/login/2fa", wrongCode, nil); response.Status != http.StatusUnauthorized {
+		t.Fatalf("wrong code answered %v", response.Status)
+	}
+
+	if failures := accountFailures(t, user); failures != 1 {
+		t.Fatalf("account has %v failures after a wrong second factor", failures)
+	}
+
+	if response := call(t, router, http.MethodPost, "/login/2fa", wrongCode, nil); response.Status != http.StatusTooManyRequests {
+		t.Errorf("second factor during the backoff answered %v", response.Status)
+	}
+
+	// The password alone must not forgive the failed second factor
+	skipLoginBackoff(t)
+	challengeToken = startLogin()
+
+	if failures := accountFailures(t, user); failures != 1 {
+		t.Errorf("account has %v failures after the password was accepted", failures)
+	}
+
+	response := call(t, router, http.MethodPost, "/login/2fa", gin.H{"challengeToken": challengeToken, "recoveryCode": recoveryCode}, nil)
+
+	if response.Status != http.StatusOK {
+		t.Fatalf("recovery code answered %v: %v", response.Status, response.Message)
+	}
+
+	if failures := accountFailures(t, user); failures != 0 {
+		t.Errorf("account has %v failures after logging in", failures)
+	}
+}
@@ -2,47 +2,86 @@ package routes
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/middlewares"
 	"github.com/saheemshafi/gin-basic-api/models"
 	"github.com/saheemshafi/gin-basic-api/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func CreateAccount(ctx *gin.Context) {
 
-	var user models.User
+	var body struct {
+		models.User
+		InviteCode string `json:"inviteCode"`
+	}
 
-	if err := ctx.ShouldBindJSON(&user); err != nil {
+	if err := ctx.ShouldBindJSON(&body); err != nil {
 		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	var existingUser models.User
+	user := body.User
 
-	db.FindOne(
-		context.Background(),
-		models.UserCollection,
-		bson.M{"email": user.Email},
-	).Decode(&existingUser)
+	if errs := utils.ValidatePassword("password", user.Password, user.Name, user.Email); errs != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Password doesn't meet the requirements", errs)
+		return
+	}
 
-	if existingUser.Email == user.Email {
-		utils.WriteResponse(ctx, http.StatusConflict, "User with email already exists")
+	invite, status, err := admitRegistration(body.InviteCode)
+
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.Println(err)
+			utils.WriteResponse(ctx, status, "Something went wrong")
+			return
+		}
+
+		utils.WriteResponse(ctx, status, err.Error())
 		return
 	}
 
-	_, err := user.Insert()
+	// Only take the fields a user is allowed to pick at signup
+	user = models.User{
+		Name:     user.Name,
+		Email:    user.Email,
+		Password: user.Password,
+		Role:     models.DefaultRole,
+	}
+
+	if invite != nil {
+		user.Invite = &invite.Id
+	}
+
+	_, err = user.Insert()
+	finishRegistration(invite, user.Id, err == nil)
+
+	// The unique email index settles concurrent signups
+	if mongo.IsDuplicateKeyError(err) {
+		utils.WriteResponse(ctx, http.StatusConflict, "User with email already exists")
+		return
+	}
 
 	if err != nil {
 		utils.WriteResponse(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	if err := sendVerificationEmail(user); err != nil {
+		log.Println(err)
+	}
+
 	user.Password = ""
 	utils.WriteResponse(ctx, http.StatusOK, "Account created", user)
 }
@@ -58,50 +97,210 @@ func Login(ctx *gin.Context) {
 		return
 	}
 
+	credentials.Email = models.NormalizeEmail(credentials.Email)
+	accountKey := accountAttemptKey(credentials.Email)
+	ipKey := ipAttemptKey(ctx.ClientIP())
+
+	if wait := loginRetryAfter(accountKey, ipKey); wait > 0 {
+		middlewares.RecordAuthEvent(ctx, models.AuthEvent{
+			Email:   credentials.Email,
+			Type:    models.EventLogin,
+			Outcome: models.OutcomeFailure,
+			Reason:  "locked out",
+		})
+
+		writeLockedOut(ctx, wait)
+		return
+	}
+
 	result := db.FindOne(
 		context.Background(),
 		models.UserCollection,
 		bson.M{"email": credentials.Email},
 	)
 
-	if err := result.Err(); err != nil {
-		utils.WriteResponse(ctx, http.StatusNotFound, err.Error())
-		return
-	}
-
 	var user models.User
 
-	if err := result.Decode(&user); err != nil {
+	if err := result.Decode(&user); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
 		log.Println(err.Error())
 		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
 		return
 	}
 
-	if !utils.ComparePasswordHashes(credentials.Password, user.Password) {
+	// Unknown emails get the same answer and timing as a wrong password
+	hash := user.Password
+
+	if hash == "" {
+		hash = dummyPasswordHash()
+	}
+
+	if !utils.ComparePasswordHashes(credentials.Password, hash) || user.Password == "" {
+		recordLoginFailure(accountKey, envInt("LOGIN_MAX_ATTEMPTS", 5))
+		recordLoginFailure(ipKey, envInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20))
+		middlewares.RecordAuthEvent(ctx, models.AuthEvent{
+			User:    user.Id,
+			Email:   credentials.Email,
+			Type:    models.EventLogin,
+			Outcome: models.OutcomeFailure,
+			Reason:  "invalid credentials",
+		})
 		utils.WriteResponse(ctx, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
 
-	sessionTime := time.Now().Add(24 * time.Hour)
-	token, err := utils.EncodeJWT(user.Id.Hex(), sessionTime)
+	if utils.PasswordNeedsRehash(user.Password) {
+		rehashPassword(user, credentials.Password)
+	}
+
+	if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "login" && !user.EmailVerified {
+		authEvent(ctx, models.EventLogin, user.Id, models.OutcomeFailure, "email not verified")
+		utils.WriteResponse(ctx, http.StatusForbidden, "Verify your email before logging in")
+		return
+	}
+
+	completeLogin(ctx, user, models.EventLogin)
+}
+
+func Refresh(ctx *gin.Context) {
+	refreshToken, fromCookie := readRefreshToken(ctx)
+
+	if refreshToken == "" {
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Refresh token missing")
+		return
+	}
+
+	if fromCookie && !refreshCSRFValid(ctx, refreshToken) {
+		utils.WriteResponse(ctx, http.StatusForbidden, "Invalid CSRF token")
+		return
+	}
+
+	hash := utils.HashToken(refreshToken)
+	nextToken, err := utils.GenerateToken(32)
 
 	if err != nil {
-		utils.WriteResponse(ctx, http.StatusOK, "Failed to login")
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to refresh session")
 		return
 	}
 
-	ctx.SetCookie(
-		"token",
-		token,
-		int(time.Until(sessionTime).Seconds()),
-		"/",
-		"localhost",
-		false,
-		true,
+	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	result := db.UpdateOne(
+		context.Background(),
+		models.SessionCollection,
+		bson.M{
+			"refreshToken": hash,
+			"revoked":      false,
+			"expiresAt":    bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+		},
+		bson.M{
+			"$set": bson.M{
+				"refreshToken": utils.HashToken(nextToken),
+				"updatedAt":    primitive.NewDateTimeFromTime(time.Now()),
+			},
+			"$push": bson.M{
+				"rotatedTokens": hash,
+			},
+		},
+		options,
 	)
 
-	utils.WriteResponse(ctx, http.StatusOK, "Logged in", token)
+	if err := result.Err(); err != nil {
+
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+			utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to refresh session")
+			return
+		}
+
+		// A token that was already rotated out is being replayed, so the
+		// whole family is treated as compromised.
+		reused := db.UpdateOne(
+			context.Background(),
+			models.SessionCollection,
+			bson.M{"rotatedTokens": hash},
+			bson.M{
+				"$set": bson.M{
+					"revoked":   true,
+					"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+				},
+			},
+		)
+
+		var compromised models.Session
+
+		if reused.Decode(&compromised) == nil {
+			log.Println("Refresh token reuse detected, revoked session")
+			authEvent(ctx, models.EventRefresh, compromised.User, models.OutcomeFailure, "refresh token reuse")
+		}
+
+		clearSessionCookies(ctx)
+		utils.WriteResponse(ctx, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	var session models.Session
+	result.Decode(&session)
+
+	tokens, err := issueTokens(ctx, session, nextToken)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to refresh session")
+		return
+	}
+
+	if session.CSRFToken == "" {
+		csrfToken, err := upgradeLegacySession(ctx, session)
+
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+			utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to refresh session")
+			return
+		}
+
+		if csrfToken != "" {
+			tokens["csrfToken"] = csrfToken
+		}
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Session refreshed", tokens)
+}
+
+func Logout(ctx *gin.Context) {
+	refreshToken, fromCookie := readRefreshToken(ctx)
+
+	if fromCookie && !refreshCSRFValid(ctx, refreshToken) {
+		utils.WriteResponse(ctx, http.StatusForbidden, "Invalid CSRF token")
+		return
+	}
+
+	if refreshToken != "" {
+		result := db.UpdateOne(
+			context.Background(),
+			models.SessionCollection,
+			bson.M{"refreshToken": utils.HashToken(refreshToken)},
+			bson.M{
+				"$set": bson.M{
+					"revoked":   true,
+					"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+				},
+			},
+		)
+
+		if err := result.Err(); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Println(err)
+			utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to logout")
+			return
+		}
 
+		var session models.Session
+
+		if result.Decode(&session) == nil {
+			authEvent(ctx, models.EventLogout, session.User, models.OutcomeSuccess, "")
+		}
+	}
+
+	clearSessionCookies(ctx)
+	utils.WriteResponse(ctx, http.StatusOK, "Logged out")
 }
 
 func UpdateUser(ctx *gin.Context) {
@@ -110,7 +309,8 @@ func UpdateUser(ctx *gin.Context) {
 	user := userFromCtx.(models.User)
 
 	var updates struct {
-		Name string `binding:"required"`
+		Name string
+		Bio  *string `binding:"omitempty,max=500"`
 	}
 
 	if err := ctx.ShouldBindJSON(&updates); err != nil {
@@ -118,6 +318,24 @@ func UpdateUser(ctx *gin.Context) {
 		return
 	}
 
+	changes := bson.M{
+		"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+	}
+
+	if strings.TrimSpace(updates.Name) != "" {
+		changes["name"] = updates.Name
+	}
+
+	// Bio can be cleared by sending an empty string
+	if updates.Bio != nil {
+		changes["bio"] = strings.TrimSpace(*updates.Bio)
+	}
+
+	if len(changes) == 1 {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Nothing to update")
+		return
+	}
+
 	result := db.UpdateOne(
 		context.Background(),
 		models.UserCollection,
@@ -125,10 +343,7 @@ func UpdateUser(ctx *gin.Context) {
 			"_id": user.Id,
 		},
 		bson.M{
-			"$set": bson.M{
-				"name":      updates.Name,
-				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
-			},
+			"$set": changes,
 		},
 	)
 
@@ -139,3 +354,109 @@ func UpdateUser(ctx *gin.Context) {
 
 	utils.WriteResponse(ctx, http.StatusOK, "Updated user details")
 }
+
+func GetMe(ctx *gin.Context) {
+	userFromCtx, _ := ctx.Get("user")
+	user := userFromCtx.(models.User)
+
+	utils.WriteResponse(ctx, http.StatusOK, "User retrieved", user)
+}
+
+func GetProfile(ctx *gin.Context) {
+	userId, err := primitive.ObjectIDFromHex(ctx.Param("userId"))
+
+	if err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var user models.User
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": userId},
+	).Decode(&user); err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusNotFound, "User not found")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	page, limit, ok := paginate(ctx)
+
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"author": userId}
+
+	total, err := db.Count(context.Background(), models.BookCollection, filter)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve books")
+		return
+	}
+
+	options := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := db.Find(context.Background(), models.BookCollection, filter, options)
+
+	if err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve books")
+		return
+	}
+
+	books := []models.Book{}
+
+	if err := cursor.All(context.Background(), &books); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to retrieve books")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Profile retrieved", gin.H{
+		"profile": user.Profile(),
+		"books":   paginated(books, page, limit, total),
+	})
+}
+
+/*
+Upgrades a stored hash to the current algorithm and parameters. Only
+replaces the exact hash that was verified so a concurrent password change
+wins.
+*/
+func rehashPassword(user models.User, password string) {
+	hash, err := utils.HashPassword(password)
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{
+			"_id":      user.Id,
+			"password": user.Password,
+		},
+		bson.M{
+			"$set": bson.M{"password": hash},
+		},
+	)
+
+	if err := result.Err(); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saheemshafi/gin-basic-api/db"
+	"github.com/saheemshafi/gin-basic-api/models"
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	emailVerificationTTL     = 48 * time.Hour
+	verificationResendWindow = time.Minute
+)
+
+func sendVerificationEmail(user models.User) error {
+	if err := discardTokens(user.Id, models.TokenEmailVerification); err != nil {
+		return err
+	}
+
+	token, err := issueToken(user.Id, models.TokenEmailVerification, emailVerificationTTL)
+
+	if err != nil {
+		return err
+	}
+
+	return utils.SendMail(
+		user.Email,
+		"Verify your email",
+		fmt.Sprintf(
+			"Hi %v,\n\nConfirm your email address by opening the link below.\n\n%v",
+			user.Name,
+			utils.AppURL("/api/v1/users/verify-email?token="+token),
+		),
+	)
+}
+
+func VerifyEmail(ctx *gin.Context) {
+	raw := ctx.Query("token")
+
+	if raw == "" {
+		utils.WriteResponse(ctx, http.StatusBadRequest, "Verification token missing")
+		return
+	}
+
+	token, err := consumeToken(raw, models.TokenEmailVerification)
+
+	if err != nil {
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteResponse(ctx, http.StatusBadRequest, "Verification link is invalid or has expired")
+			return
+		}
+
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	result := db.UpdateOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"_id": token.User},
+		bson.M{
+			"$set": bson.M{
+				"emailVerified": true,
+				"updatedAt":     primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	)
+
+	if err := result.Err(); err != nil {
+		log.Println(err)
+		utils.WriteResponse(ctx, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, "Email verified")
+}
+
+func ResendVerification(ctx *gin.Context) {
+	var body struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		utils.WriteResponse(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	const message = "If the account needs verification a new link has been sent"
+
+	var user models.User
+
+	if err := db.FindOne(
+		context.Background(),
+		models.UserCollection,
+		bson.M{"email": models.NormalizeEmail(body.Email)},
+	).Decode(&user); err != nil || user.EmailVerified {
+		utils.WriteResponse(ctx, http.StatusOK, message)
+		return
+	}
+
+	recent := db.FindOne(
+		context.Background(),
+		models.TokenCollection,
+		bson.M{
+			"user":      user.Id,
+			"purpose":   models.TokenEmailVerification,
+			"createdAt": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now().Add(-verificationResendWindow))},
+		})
+
+	// Throttled resends answer the same so they don't reveal the account
+	if recent.Err() == nil {
+		utils.WriteResponse(ctx, http.StatusOK, message)
+		return
+	}
+
+	if err := sendVerificationEmail(user); err != nil {
+		log.Println(err)
+	}
+
+	utils.WriteResponse(ctx, http.StatusOK, message)
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	apiKeyMarker       = "gba_"
+	apiKeyPrefixLength = 8
+)
+
+/*
+Generates an api key like "gba_<prefix>_<secret>" and returns it with its
+lookup prefix
+*/
+func GenerateAPIKey() (string, string, error) {
+	prefixBytes := make([]byte, apiKeyPrefixLength/2)
+
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", err
+	}
+
+	secret, err := GenerateToken(32)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := hex.EncodeToString(prefixBytes)
+
+	return apiKeyMarker + prefix + "_" + secret, prefix, nil
+}
+
+/*
+Returns the lookup prefix of an api key, or false if it isn't well formed
+*/
+func APIKeyPrefix(key string) (string, bool) {
+	rest, found := strings.CutPrefix(key, apiKeyMarker)
+
+	if !found || len(rest) <= apiKeyPrefixLength+1 || rest[apiKeyPrefixLength] != '_' {
+		return "", false
+	}
+
+	return rest[:apiKeyPrefixLength], true
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const cborMaxDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+/*
+Just enough of a CBOR (RFC 8949) decoder for WebAuthn attestation objects
+and COSE keys. Decodes the first item in data and returns it with the
+bytes that follow. Integers decode to int64, byte strings to []byte, text
+to string, arrays to []any and maps to map[any]any. Indefinite lengths
+aren't used by authenticators and are rejected.
+*/
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("cbor: nested too deeply")
+	}
+
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	// Simple values and floats keep their own meaning for the argument
+	if major == 7 {
+		return decodeCBORSimple(info, data[1:])
+	}
+
+	argument, rest, err := cborArgument(info, data[1:])
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+
+		return int64(argument), rest, nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+
+		return -1 - int64(argument), rest, nil
+	case 2, 3:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+
+		value := rest[:argument]
+
+		if major == 3 {
+			return string(value), rest[argument:], nil
+		}
+
+		return append([]byte{}, value...), rest[argument:], nil
+	case 4:
+		// Every item takes at least a byte, bigger counts can't be valid
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+
+		items := make([]any, 0, argument)
+
+		for i := uint64(0); i < argument; i++ {
+			var item any
+
+			if item, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			items = append(items, item)
+		}
+
+		return items, rest, nil
+	case 5:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+
+		entries := make(map[any]any, argument)
+
+		for i := uint64(0); i < argument; i++ {
+			var key, value any
+
+			if key, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key %T", key)
+			}
+
+			if value, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			entries[key] = value
+		}
+
+		return entries, rest, nil
+	case 6:
+		// Tags only annotate the item that follows
+		return decodeCBORItem(rest, depth+1)
+	}
+
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %v", major)
+}
+
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+	if info < 24 {
+		return uint64(info), data, nil
+	}
+
+	size := 0
+
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, nil, errors.New("cbor: indefinite or reserved length")
+	}
+
+	if len(data) < size {
+		return 0, nil, errCBORTruncated
+	}
+
+	var argument uint64
+
+	for _, b := range data[:size] {
+		argument = argument<<8 | uint64(b)
+	}
+
+	return argument, data[size:], nil
+}
+
+func decodeCBORSimple(info byte, data []byte) (any, []byte, error) {
+	switch info {
+	case 20:
+		return false, data, nil
+	case 21:
+		return true, data, nil
+	case 22, 23:
+		return nil, data, nil
+	case 26:
+		if len(data) < 4 {
+			return nil, nil, errCBORTruncated
+		}
+
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[4:], nil
+	case 27:
+		if len(data) < 8 {
+			return nil, nil, errCBORTruncated
+		}
+
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	}
+
+	return nil, nil, fmt.Errorf("cbor: unsupported simple value %v", info)
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// {1: 2, "a": [-1, h'ff', true]} followed by one extra byte
+	data := []byte{0xa2, 0x01, 0x02, 0x61, 'a', 0x83, 0x20, 0x41, 0xff, 0xf5, 0x00}
+
+	decoded, rest, err := decodeCBOR(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[any]any{
+		int64(1): int64(2),
+		"a":      []any{int64(-1), []byte{0xff}, true},
+	}
+
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("decoded %#v, want %#v", decoded, expected)
+	}
+
+	if !bytes.Equal(rest, []byte{0x00}) {
+		t.Errorf("rest = %x", rest)
+	}
+}
+
+func TestDecodeCBORTruncated(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":                {},
+		"missing argument":     {0x19, 0x01},
+		"short byte string":    {0x43, 0x01, 0x02},
+		"short text string":    {0x62, 'a'},
+		"missing array item":   {0x83, 0x01, 0x02},
+		"missing map value":    {0xa1, 0x01},
+		"huge array count":     {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge map count":       {0xba, 0xff, 0xff, 0xff, 0xff},
+		"huge byte string":     {0x5b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"missing tagged value": {0xd8, 0x18},
+		"short float":          {0xfa, 0x00, 0x00},
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(data); !errors.Is(err, errCBORTruncated) {
+				t.Errorf("err = %v, want %v", err, errCBORTruncated)
+			}
+		})
+	}
+}
+
+func nestedArrays(depth int) []byte {
+	return append(bytes.Repeat([]byte{0x81}, depth), 0x01)
+}
+
+func TestDecodeCBORDepth(t *testing.T) {
+	if _, _, err := decodeCBOR(nestedArrays(cborMaxDepth)); err != nil {
+		t.Errorf("nesting up to the limit was rejected: %v", err)
+	}
+
+	if _, _, err := decodeCBOR(nestedArrays(cborMaxDepth + 1)); err == nil {
+		t.Error("nesting past the limit was accepted")
+	}
+
+	// Tags count as nesting too, or they could be stacked without bound
+	if _, _, err := decodeCBOR(append(bytes.Repeat([]byte{0xc1}, 1000), 0x01)); err == nil {
+		t.Error("deeply stacked tags were accepted")
+	}
+}
+
+func TestDecodeCBORRejects(t *testing.T) {
+	tests := map[string][]byte{
+		"indefinite array":  {0x9f, 0x01, 0xff},
+		"byte string key":   {0xa1, 0x41, 0x01, 0x01},
+		"integer overflow":  {0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"unsupported value": {0xf8, 0x20},
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(data); err == nil {
+				t.Error("invalid cbor was accepted")
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -55,3 +56,19 @@ func DeleteFile(publicId string, resourceType api.AssetType) (*uploader.DestroyR
 		ResourceType: resourceType.String(),
 	})
 }
+
+/*
+Delivery url for an image cropped to a square of size pixels, centered on
+a face when there is one
+*/
+func ImageURL(publicId string, size int) (string, error) {
+	image, err := cloudinaryInstance.Image(publicId)
+
+	if err != nil {
+		return "", err
+	}
+
+	image.Transformation = fmt.Sprintf("c_fill,g_face,w_%d,h_%d,f_auto,q_auto", size, size)
+
+	return image.String()
+}
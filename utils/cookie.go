@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Attributes shared by every cookie the api sets. SessionName and Path
+apply to the access token cookie, the other cookies keep their own names
+and paths.
+*/
+type CookieConfig struct {
+	SessionName string
+	RefreshName string
+	CSRFName    string
+	Domain      string
+	Path        string
+	Secure      bool
+	SameSite    http.SameSite
+}
+
+var (
+	cookieConfig     CookieConfig
+	cookieConfigOnce sync.Once
+)
+
+/*
+Reads the cookie settings from COOKIE_* environment variables once. The
+domain defaults to host only cookies and SameSite to lax.
+*/
+func GetCookieConfig() CookieConfig {
+	cookieConfigOnce.Do(func() {
+		config := CookieConfig{
+			SessionName: envOr("COOKIE_SESSION_NAME", "token"),
+			RefreshName: envOr("COOKIE_REFRESH_NAME", "refresh_token"),
+			CSRFName:    envOr("COOKIE_CSRF_NAME", "csrf_token"),
+			Domain:      os.Getenv("COOKIE_DOMAIN"),
+			Path:        envOr("COOKIE_PATH", "/"),
+			Secure:      os.Getenv("COOKIE_SECURE") == "true",
+			SameSite:    http.SameSiteLaxMode,
+		}
+
+		switch strings.ToLower(os.Getenv("COOKIE_SAMESITE")) {
+		case "strict":
+			config.SameSite = http.SameSiteStrictMode
+		case "none":
+			config.SameSite = http.SameSiteNoneMode
+		}
+
+		// Browsers drop SameSite=None cookies that aren't secure
+		if config.SameSite == http.SameSiteNoneMode && !config.Secure {
+			log.Println("COOKIE_SAMESITE=none requires secure cookies, setting COOKIE_SECURE")
+			config.Secure = true
+		}
+
+		cookieConfig = config
+	})
+
+	return cookieConfig
+}
+
+/*
+Sets a cookie with the configured domain, secure flag and SameSite. Pass a
+negative maxAge to delete it.
+*/
+func SetCookie(ctx *gin.Context, name string, value string, maxAge int, path string, httpOnly bool) {
+	config := GetCookieConfig()
+
+	ctx.SetSameSite(config.SameSite)
+	ctx.SetCookie(name, value, maxAge, path, config.Domain, config.Secure, httpOnly)
+}
+
+/*
+SetCookie for cookies that have to come back on a redirect from another
+site, like the OIDC callback or a link opened from an email. Strict would
+keep them from being sent so those fall back to lax.
+*/
+func SetRedirectCookie(ctx *gin.Context, name string, value string, maxAge int, path string) {
+	config := GetCookieConfig()
+	sameSite := config.SameSite
+
+	if sameSite == http.SameSiteStrictMode {
+		sameSite = http.SameSiteLaxMode
+	}
+
+	ctx.SetSameSite(sameSite)
+	ctx.SetCookie(name, value, maxAge, path, config.Domain, config.Secure, true)
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers accepted for passkeys
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+var COSEAlgorithms = []int{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256}
+
+/*
+Public key of a credential decoded from its COSE_Key (RFC 9053) encoding
+*/
+type COSEKey struct {
+	Algorithm int
+	PublicKey crypto.PublicKey
+}
+
+func ParseCOSEKey(data []byte) (COSEKey, error) {
+	var key COSEKey
+
+	decoded, rest, err := decodeCBOR(data)
+
+	if err != nil {
+		return key, err
+	}
+
+	if len(rest) != 0 {
+		return key, errors.New("cose: trailing data after key")
+	}
+
+	fields, ok := decoded.(map[any]any)
+
+	if !ok {
+		return key, errors.New("cose: key isn't a map")
+	}
+
+	keyType, _ := fields[int64(1)].(int64)
+	algorithm, _ := fields[int64(3)].(int64)
+	key.Algorithm = int(algorithm)
+
+	switch {
+	case keyType == 2 && algorithm == COSEAlgES256:
+		curve, _ := fields[int64(-1)].(int64)
+		x, _ := fields[int64(-2)].([]byte)
+		y, _ := fields[int64(-3)].([]byte)
+
+		if curve != 1 || len(x) != 32 || len(y) != 32 {
+			return key, errors.New("cose: invalid P-256 key")
+		}
+
+		public := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return key, errors.New("cose: point isn't on the curve")
+		}
+
+		key.PublicKey = public
+	case keyType == 1 && algorithm == COSEAlgEdDSA:
+		curve, _ := fields[int64(-1)].(int64)
+		x, _ := fields[int64(-2)].([]byte)
+
+		if curve != 6 || len(x) != ed25519.PublicKeySize {
+			return key, errors.New("cose: invalid Ed25519 key")
+		}
+
+		key.PublicKey = ed25519.PublicKey(x)
+	case keyType == 3 && algorithm == COSEAlgRS256:
+		n, _ := fields[int64(-1)].([]byte)
+		e, _ := fields[int64(-2)].([]byte)
+
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return key, errors.New("cose: invalid RSA key")
+		}
+
+		key.PublicKey = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	default:
+		return key, fmt.Errorf("cose: unsupported key type %v with algorithm %v", keyType, algorithm)
+	}
+
+	return key, nil
+}
+
+/*
+Checks a signature made by the key over message
+*/
+func (key COSEKey) Verify(message []byte, signature []byte) bool {
+	switch public := key.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(public, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(public, message, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature) == nil
+	}
+
+	return false
+}
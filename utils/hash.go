@@ -1,14 +1,216 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashBytes, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Compare(password string, hash string) bool
+	// Reports whether a hash was made with another algorithm or weaker
+	// parameters than this hasher uses
+	NeedsRehash(hash string) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	hashBytes, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
 	return string(hashBytes), err
 }
 
+func (hasher *BcryptHasher) Compare(password string, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (hasher *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != hasher.Cost
+}
+
+/*
+Argon2id hasher producing PHC strings like
+$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+*/
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+const (
+	// Shorter keys are too easy to collide with
+	argon2idMinKeyLength = 16
+	// Memory in KiB a stored hash may ask for, 1 GiB. A hash asking for more
+	// would let one login exhaust the server.
+	argon2idMaxMemory = 1024 * 1024
+)
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, hasher.SaltLength)
+
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, hasher.Iterations, hasher.Memory, hasher.Parallelism, hasher.KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		hasher.Memory,
+		hasher.Iterations,
+		hasher.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func decodeArgon2id(hash string) (*argon2idParams, error) {
+	parts := strings.Split(hash, "$")
+
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version")
+	}
+
+	var params argon2idParams
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return nil, err
+	}
+
+	if params.memory > argon2idMaxMemory || params.iterations < 1 || params.parallelism < 1 {
+		return nil, fmt.Errorf("invalid argon2id parameters")
+	}
+
+	var err error
+
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+
+	if len(params.key) < argon2idMinKeyLength {
+		return nil, fmt.Errorf("argon2id key is too short")
+	}
+
+	return &params, nil
+}
+
+func (hasher *Argon2idHasher) Compare(password string, hash string) bool {
+	params, err := decodeArgon2id(hash)
+
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+
+	return subtle.ConstantTimeCompare(key, params.key) == 1
+}
+
+func (hasher *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := decodeArgon2id(hash)
+
+	return err != nil ||
+		params.memory != hasher.Memory ||
+		params.iterations != hasher.Iterations ||
+		params.parallelism != hasher.Parallelism ||
+		uint32(len(params.salt)) != hasher.SaltLength ||
+		uint32(len(params.key)) != hasher.KeyLength
+}
+
+var (
+	passwordHasher     PasswordHasher
+	passwordHasherOnce sync.Once
+)
+
+func envUint(key string, fallback uint64, bits int) uint64 {
+	if value, err := strconv.ParseUint(os.Getenv(key), 10, bits); err == nil && value > 0 {
+		return value
+	}
+
+	return fallback
+}
+
+/*
+Hasher new passwords are hashed with, picked by PASSWORD_HASHER
+("bcrypt" or "argon2id") and tuned through BCRYPT_COST and ARGON2_*
+*/
+func GetPasswordHasher() PasswordHasher {
+	passwordHasherOnce.Do(func() {
+		if os.Getenv("PASSWORD_HASHER") == "argon2id" {
+			passwordHasher = &Argon2idHasher{
+				Memory:      uint32(min(envUint("ARGON2_MEMORY", 64*1024, 32), argon2idMaxMemory)),
+				Iterations:  uint32(envUint("ARGON2_ITERATIONS", 3, 32)),
+				Parallelism: uint8(envUint("ARGON2_PARALLELISM", 2, 8)),
+				SaltLength:  16,
+				KeyLength:   32,
+			}
+			return
+		}
+
+		cost := int(envUint("BCRYPT_COST", 10, 8))
+		passwordHasher = &BcryptHasher{Cost: max(min(cost, bcrypt.MaxCost), bcrypt.MinCost)}
+	})
+
+	return passwordHasher
+}
+
+/*
+Picks the hasher able to verify a stored hash from its prefix
+*/
+func hasherFor(hash string) PasswordHasher {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return &Argon2idHasher{}
+	}
+
+	return &BcryptHasher{}
+}
+
+func HashPassword(password string) (string, error) {
+	return GetPasswordHasher().Hash(password)
+}
+
 func ComparePasswordHashes(password string, hashString string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(hashString), []byte(password)) == nil
+	return hasherFor(hashString).Compare(password, hashString)
+}
+
+/*
+Reports whether a stored hash should be replaced on the next successful
+login
+*/
+func PasswordNeedsRehash(hashString string) bool {
+	return GetPasswordHasher().NeedsRehash(hashString)
 }
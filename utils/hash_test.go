@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cheap enough to keep the tests fast
+var testArgon2idHasher = &Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"bcrypt":   &BcryptHasher{Cost: 4},
+		"argon2id": testArgon2idHasher,
+	}
+
+	for name, hasher := range hashers {
+		t.Run(name, func(t *testing.T) {
+			hash, err := hasher.Hash("correct horse battery staple")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !hasher.Compare("correct horse battery staple", hash) {
+				t.Error("the password didn't match its hash")
+			}
+
+			if hasher.Compare("wrong password", hash) {
+				t.Error("a wrong password matched")
+			}
+
+			if !ComparePasswordHashes("correct horse battery staple", hash) {
+				t.Error("the hash wasn't recognised by its prefix")
+			}
+
+			if hasher.NeedsRehash(hash) {
+				t.Error("a fresh hash needs a rehash")
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := (&BcryptHasher{Cost: 4}).Hash("password")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	argon2idHash, err := testArgon2idHasher.Hash("password")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		hasher PasswordHasher
+		hash   string
+	}{
+		"bcrypt to argon2id": {hasher: testArgon2idHasher, hash: bcryptHash},
+		"argon2id to bcrypt": {hasher: &BcryptHasher{Cost: 4}, hash: argon2idHash},
+		"higher bcrypt cost": {hasher: &BcryptHasher{Cost: 5}, hash: bcryptHash},
+		"more argon2id memory": {
+			hasher: &Argon2idHasher{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+			hash:   argon2idHash,
+		},
+		"longer argon2id key": {
+			hasher: &Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64},
+			hash:   argon2idHash,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if !test.hasher.NeedsRehash(test.hash) {
+				t.Error("the hash doesn't need a rehash")
+			}
+		})
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	key := strings.Repeat("A", 43)
+
+	tests := map[string]string{
+		"empty key":        "$argon2id$v=19$m=1024,t=1,p=1$AAAA$",
+		"short key":        "$argon2id$v=19$m=1024,t=1,p=1$AAAA$AAAAAAAA",
+		"no iterations":    "$argon2id$v=19$m=1024,t=0,p=1$AAAA$" + key,
+		"no parallelism":   "$argon2id$v=19$m=1024,t=1,p=0$AAAA$" + key,
+		"huge memory":      "$argon2id$v=19$m=4294967295,t=1,p=1$AAAA$" + key,
+		"negative memory":  "$argon2id$v=19$m=-1,t=1,p=1$AAAA$" + key,
+		"other version":    "$argon2id$v=16$m=1024,t=1,p=1$AAAA$" + key,
+		"missing sections": "$argon2id$v=19$m=1024,t=1,p=1",
+		"bad salt":         "$argon2id$v=19$m=1024,t=1,p=1$!!!!$" + key,
+	}
+
+	for name, hash := range tests {
+		t.Run(name, func(t *testing.T) {
+			if testArgon2idHasher.Compare("password", hash) {
+				t.Error("the malformed hash matched")
+			}
+
+			if !testArgon2idHasher.NeedsRehash(hash) {
+				t.Error("the malformed hash doesn't need a rehash")
+			}
+		})
+	}
+}
@@ -1,29 +1,231 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func EncodeJWT(id string, expires time.Time) (string, error) {
+const AccessTokenTTL = 15 * time.Minute
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ID:        id,
+type accessClaims struct {
+	Version int          `json:"ver"`
+	Actor   *actorClaims `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+/*
+RFC 8693 actor claim naming who is acting on behalf of the subject
+*/
+type actorClaims struct {
+	Subject string `json:"sub"`
+}
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private any
+	public  any
+	jwk     *JWK
+}
+
+var activeKey *signingKey
+
+/*
+Keys tokens are accepted from, indexed by "kid". Holds the active key plus
+retired ones still inside their rotation window.
+*/
+var verificationKeys = map[string]*signingKey{}
+
+/*
+Loads the token signing keys. With JWT_SIGNING_KEY_FILE set tokens are
+signed with that RSA (RS256) or Ed25519 (EdDSA) PEM key and
+JWT_VERIFICATION_KEY_FILES lists retired keys that are still accepted.
+Otherwise tokens fall back to HS256 with JWT_SECRET.
+*/
+func InitializeJWT(connectionCh chan<- string) {
+	keyFile := os.Getenv("JWT_SIGNING_KEY_FILE")
+
+	if keyFile == "" {
+		secret := os.Getenv("JWT_SECRET")
+
+		if secret == "" {
+			log.Fatal("JWT_SECRET or JWT_SIGNING_KEY_FILE must be set")
+		}
+
+		activeKey = &signingKey{
+			method:  jwt.SigningMethodHS256,
+			private: []byte(secret),
+			public:  []byte(secret),
+		}
+		verificationKeys[""] = activeKey
+
+		connectionCh <- "Signing tokens with HS256"
+		return
+	}
+
+	key, err := loadSigningKey(keyFile)
+
+	if err != nil {
+		log.Fatalf("Failed to load %v: %v", keyFile, err)
+	}
+
+	if key.private == nil {
+		log.Fatalf("%v doesn't contain a private key", keyFile)
+	}
+
+	activeKey = key
+	verificationKeys[key.kid] = key
+
+	for _, file := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		if file = strings.TrimSpace(file); file == "" {
+			continue
+		}
+
+		key, err := loadSigningKey(file)
+
+		if err != nil {
+			log.Fatalf("Failed to load %v: %v", file, err)
+		}
+
+		verificationKeys[key.kid] = key
+	}
+
+	connectionCh <- fmt.Sprintf("Signing tokens with %v key %v", activeKey.method.Alg(), activeKey.kid)
+}
+
+/*
+Reads a PEM encoded RSA or Ed25519 key. Private keys can sign and verify,
+public keys only verify.
+*/
+func loadSigningKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var private, public any
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %v", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if signer, ok := private.(crypto.Signer); ok {
+		public = signer.Public()
+	}
+
+	key := &signingKey{private: private, public: public}
+
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	jwk, err := NewJWK(public, key.method.Alg())
+
+	if err != nil {
+		return nil, err
+	}
+
+	key.kid = jwk.Kid
+	key.jwk = &jwk
+
+	return key, nil
+}
+
+/*
+Signs an access token for the user. The session id goes in "jti" so the
+token can be revoked together with its session, and "ver" carries the
+user's token version so a password change invalidates it.
+*/
+func EncodeJWT(userId string, sessionId string, version int, expires time.Time) (string, error) {
+	return signAccessToken(accessClaims{
+		Version: version,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   userId,
+			ID:        sessionId,
+		},
 	})
+}
 
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+/*
+Signs an access token for userId that was issued to actorId, an admin
+impersonating the user. The actor goes in the "act" claim.
+*/
+func EncodeImpersonationJWT(userId string, actorId string, sessionId string, version int, expires time.Time) (string, error) {
+	return signAccessToken(accessClaims{
+		Version: version,
+		Actor:   &actorClaims{Subject: actorId},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   userId,
+			ID:        sessionId,
+		},
+	})
+}
+
+func signAccessToken(claims accessClaims) (string, error) {
+	token := jwt.NewWithClaims(activeKey.method, claims)
+
+	if activeKey.kid != "" {
+		token.Header["kid"] = activeKey.kid
+	}
+
+	return token.SignedString(activeKey.private)
 }
 
 func DecodeJWT(tokenString string) (jwt.MapClaims, error) {
 
 	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, ok := verificationKeys[kid]
+
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+
+		// Never let the token pick its own algorithm
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+
+		return key.public, nil
+	}, jwt.WithExpirationRequired())
 
 	if err != nil {
 		return nil, errors.New("failed to decode token")
@@ -37,3 +239,19 @@ func DecodeJWT(tokenString string) (jwt.MapClaims, error) {
 
 	return claims, nil
 }
+
+/*
+Public verification keys for the JWKS endpoint. Empty when signing with a
+shared secret.
+*/
+func JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range verificationKeys {
+		if key.jwk != nil {
+			set.Keys = append(set.Keys, *key.jwk)
+		}
+	}
+
+	return set
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+/*
+Delivers mail through a plain SMTP relay
+*/
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (mailer *SMTPMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+
+	if mailer.Username != "" {
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + mailer.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(mailer.Host+":"+mailer.Port, auth, mailer.From, []string{to}, []byte(message))
+}
+
+/*
+Local development mailer. Appends every mail to Path, or logs it when no
+path is set.
+*/
+type LogMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (mailer *LogMailer) Send(to string, subject string, body string) error {
+	entry := fmt.Sprintf("[%v] To: %v\nSubject: %v\n\n%v\n\n", time.Now().Format(time.RFC3339), to, subject, body)
+
+	if mailer.Path == "" {
+		log.Print(entry)
+		return nil
+	}
+
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+
+	file, err := os.OpenFile(mailer.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	_, err = file.WriteString(entry)
+	return err
+}
+
+var mailerInstance Mailer
+
+func InitializeMailer(connectionCh chan<- string) {
+	driver := os.Getenv("MAIL_DRIVER")
+
+	switch driver {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		port := os.Getenv("SMTP_PORT")
+		from := os.Getenv("MAIL_FROM")
+
+		if host == "" || port == "" || from == "" {
+			log.Fatal("Empty smtp configuration")
+		}
+
+		mailerInstance = &SMTPMailer{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	case "", "log":
+		driver = "log"
+		mailerInstance = &LogMailer{Path: os.Getenv("MAIL_LOG_FILE")}
+	default:
+		log.Fatalf("Unknown mail driver %v", driver)
+	}
+
+	connectionCh <- fmt.Sprintf("Mailer configured with %v driver", driver)
+}
+
+/*
+Replaces the mailer, useful for swapping in a custom implementation
+*/
+func SetMailer(mailer Mailer) {
+	mailerInstance = mailer
+}
+
+func SendMail(to string, subject string, body string) error {
+	return mailerInstance.Send(to, subject, body)
+}
+
+/*
+Builds an absolute link to the client app from APP_URL
+*/
+func AppURL(path string) string {
+	base := os.Getenv("APP_URL")
+
+	if base == "" {
+		base = "http://localhost:5000"
+	}
+
+	return strings.TrimSuffix(base, "/") + path
+}
@@ -0,0 +1,329 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+Relying party configuration for one OpenID Connect provider. Endpoints
+come from the provider's discovery document and are fetched lazily.
+*/
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+	keysAt    time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+/*
+Claims we rely on from a validated ID token
+*/
+type IDTokenClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+var oidcProviders = map[string]*OIDCProvider{}
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+/*
+Registers providers listed in OIDC_PROVIDERS, e.g. "google,local". Each
+provider is configured through OIDC_<NAME>_ISSUER, _CLIENT_ID,
+_CLIENT_SECRET, _REDIRECT_URL and optionally _SCOPES.
+*/
+func InitializeOIDC(connectionCh chan<- string) {
+	var names []string
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := &OIDCProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			provider.Scopes = strings.Fields(scopes)
+		}
+
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			log.Fatalf("Empty oidc configuration for %v", name)
+		}
+
+		oidcProviders[name] = provider
+		names = append(names, name)
+	}
+
+	connectionCh <- fmt.Sprintf("OIDC providers configured: %v", names)
+}
+
+func GetOIDCProvider(name string) (*OIDCProvider, bool) {
+	provider, ok := oidcProviders[name]
+	return provider, ok
+}
+
+func getJSON(endpoint string, target any) error {
+	response, err := oidcClient.Get(endpoint)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v returned %v", endpoint, response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+func (provider *OIDCProvider) discover() (*oidcDiscovery, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+
+	if err := getJSON(provider.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != provider.Issuer {
+		return nil, fmt.Errorf("discovery issuer %v doesn't match %v", discovery.Issuer, provider.Issuer)
+	}
+
+	provider.discovery = &discovery
+	return provider.discovery, nil
+}
+
+/*
+Returns the verification key for kid, refetching the JWKS when the kid is
+unknown so provider key rotation is picked up. Refetches are limited to one
+a minute.
+*/
+func (provider *OIDCProvider) publicKey(kid string) (crypto.PublicKey, error) {
+	discovery, err := provider.discover()
+
+	if err != nil {
+		return nil, err
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if key, ok := provider.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(provider.keysAt) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %v", kid)
+	}
+
+	var set JWKSet
+
+	if err := getJSON(discovery.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	provider.keys = keys
+	provider.keysAt = time.Now()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown signing key %v", kid)
+}
+
+/*
+Builds the authorization request url for the code flow with PKCE
+*/
+func (provider *OIDCProvider) AuthCodeURL(state string, nonce string, codeVerifier string) (string, error) {
+	discovery, err := provider.discover()
+
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", provider.RedirectURL)
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", PKCEChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+/*
+Redeems an authorization code at the token endpoint and returns the raw
+ID token
+*/
+func (provider *OIDCProvider) Exchange(code string, codeVerifier string) (string, error) {
+	discovery, err := provider.discover()
+
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("client_id", provider.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	if provider.ClientSecret != "" {
+		form.Set("client_secret", provider.ClientSecret)
+	}
+
+	response, err := oidcClient.PostForm(discovery.TokenEndpoint, form)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return "", fmt.Errorf("token endpoint returned %v: %s", response.Status, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+
+	if tokens.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+
+	return tokens.IDToken, nil
+}
+
+/*
+Validates the ID token signature against the provider's JWKS along with
+its issuer, audience, expiry and nonce
+*/
+func (provider *OIDCProvider) VerifyIDToken(rawToken string, nonce string) (*IDTokenClaims, error) {
+	var claims IDTokenClaims
+
+	discovery, err := provider.discover()
+
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(
+		rawToken,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return provider.publicKey(kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}),
+		// Tokens carry the issuer exactly as discovery reports it, the
+		// configured one may have lost a trailing slash
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(provider.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30*time.Second),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("id token is not valid")
+	}
+
+	if claims.Nonce != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	return &claims, nil
+}
+
+/*
+S256 code challenge for a PKCE code verifier
+*/
+func PKCEChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,288 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+In process OpenID Connect issuer. Its discovery document reports the issuer
+with a trailing slash like some real providers do.
+*/
+type testIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	kid       string
+	issuer    string
+	challenge string
+	idToken   string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := NewJWK(&key.PublicKey, "RS256")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{key: key, kid: jwk.Kid}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer.issuer,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize?prompt=login",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKSet{Keys: []JWK{jwk}})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		if r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("code") != "code" ||
+			r.PostForm.Get("client_id") != "client" ||
+			PKCEChallenge(r.PostForm.Get("code_verifier")) != issuer.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	issuer.issuer = issuer.server.URL + "/"
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+// Configured the way InitializeOIDC does, with the trailing slash trimmed
+func (issuer *testIssuer) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Name:        "test",
+		Issuer:      strings.TrimSuffix(issuer.issuer, "/"),
+		ClientID:    "client",
+		RedirectURL: "http://localhost:5000/api/v1/users/oidc/test/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+}
+
+func (issuer *testIssuer) claims() *IDTokenClaims {
+	return &IDTokenClaims{
+		Nonce:         "nonce",
+		Email:         "reader@example.com",
+		EmailVerified: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer.issuer,
+			Subject:   "subject",
+			Audience:  jwt.ClaimStrings{"client"},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+func (issuer *testIssuer) sign(t *testing.T, claims *IDTokenClaims, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = issuer.kid
+
+	signed, err := token.SignedString(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+
+	discovery, err := provider.discover()
+
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+
+	if discovery.TokenEndpoint != issuer.server.URL+"/token" {
+		t.Errorf("token endpoint = %v", discovery.TokenEndpoint)
+	}
+
+	if cached, _ := provider.discover(); cached != discovery {
+		t.Error("discovery document wasn't cached")
+	}
+
+	issuer.issuer = "https://attacker.example"
+
+	if _, err := issuer.provider().discover(); err == nil {
+		t.Error("discovery accepted a document for another issuer")
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	challenge := PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+
+	if challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("challenge = %v", challenge)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	raw, err := issuer.provider().AuthCodeURL("state", "nonce", "verifier")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := url.Parse(raw)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(raw, issuer.server.URL+"/authorize?") {
+		t.Errorf("url %v doesn't use the authorization endpoint", raw)
+	}
+
+	expected := map[string]string{
+		"prompt":                "login",
+		"response_type":         "code",
+		"client_id":             "client",
+		"redirect_uri":          "http://localhost:5000/api/v1/users/oidc/test/callback",
+		"scope":                 "openid email",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        PKCEChallenge("verifier"),
+		"code_challenge_method": "S256",
+	}
+
+	for name, value := range expected {
+		if got := authURL.Query().Get(name); got != value {
+			t.Errorf("%v = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.challenge = PKCEChallenge("verifier")
+	issuer.idToken = "id-token"
+	provider := issuer.provider()
+
+	idToken, err := provider.Exchange("code", "verifier")
+
+	if err != nil || idToken != "id-token" {
+		t.Fatalf("Exchange = %q, %v", idToken, err)
+	}
+
+	if _, err := provider.Exchange("code", "another-verifier"); err == nil {
+		t.Error("code was redeemed with the wrong verifier")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(claims *IDTokenClaims)
+		key    *rsa.PrivateKey
+		valid  bool
+	}{
+		{name: "valid", valid: true},
+		{
+			name:   "issuer without the trailing slash",
+			modify: func(claims *IDTokenClaims) { claims.Issuer = strings.TrimSuffix(issuer.issuer, "/") },
+		},
+		{
+			name:   "other issuer",
+			modify: func(claims *IDTokenClaims) { claims.Issuer = "https://attacker.example/" },
+		},
+		{
+			name:   "other audience",
+			modify: func(claims *IDTokenClaims) { claims.Audience = jwt.ClaimStrings{"another-client"} },
+		},
+		{
+			name:   "expired",
+			modify: func(claims *IDTokenClaims) { claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) },
+		},
+		{
+			name:   "no expiry",
+			modify: func(claims *IDTokenClaims) { claims.ExpiresAt = nil },
+		},
+		{
+			name:   "other nonce",
+			modify: func(claims *IDTokenClaims) { claims.Nonce = "another-nonce" },
+		},
+		{
+			name:   "no subject",
+			modify: func(claims *IDTokenClaims) { claims.Subject = "" },
+		},
+		{name: "bad signature", key: otherKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := issuer.claims()
+
+			if test.modify != nil {
+				test.modify(claims)
+			}
+
+			key := issuer.key
+
+			if test.key != nil {
+				key = test.key
+			}
+
+			verified, err := issuer.provider().VerifyIDToken(issuer.sign(t, claims, key), "nonce")
+
+			if test.valid && err != nil {
+				t.Fatalf("valid token was rejected: %v", err)
+			}
+
+			if !test.valid && err == nil {
+				t.Fatal("invalid token was accepted")
+			}
+
+			if test.valid && verified.Email != "reader@example.com" {
+				t.Errorf("email = %v", verified.Email)
+			}
+		})
+	}
+}
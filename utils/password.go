@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+/*
+Validation problem with a single request field
+*/
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+
+	for i, err := range errs {
+		messages[i] = err.Field + ": " + err.Message
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+// bcrypt silently ignores everything past 72 bytes, other hashers get a
+// generous bound so hashing stays cheap
+const (
+	bcryptMaxBytes   = 72
+	passwordMaxBytes = 256
+)
+
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Rejects passwords containing the user's name or email
+	DisallowPersonal bool
+	// Plain SHA-1 list file, or a directory of k-anonymity range files
+	BreachedList string
+}
+
+var (
+	passwordPolicy     PasswordPolicy
+	passwordPolicyOnce sync.Once
+	breachedHashes     map[string]struct{}
+	breachedListErr    error
+)
+
+/*
+Reads the policy from PASSWORD_* environment variables once
+*/
+func GetPasswordPolicy() PasswordPolicy {
+	passwordPolicyOnce.Do(func() {
+		maxBytes := passwordMaxBytes
+
+		if _, ok := GetPasswordHasher().(*BcryptHasher); ok {
+			maxBytes = bcryptMaxBytes
+		}
+
+		policy := PasswordPolicy{
+			MinLength:        8,
+			MaxLength:        maxBytes,
+			DisallowPersonal: os.Getenv("PASSWORD_DISALLOW_PERSONAL") != "false",
+			BreachedList:     os.Getenv("PASSWORD_BREACHED_LIST"),
+		}
+
+		if value, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && value > 0 {
+			policy.MinLength = value
+		}
+
+		if value, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_LENGTH")); err == nil && value > 0 {
+			policy.MaxLength = min(value, maxBytes)
+		}
+
+		for _, class := range strings.Split(os.Getenv("PASSWORD_REQUIRE_CLASSES"), ",") {
+			switch strings.TrimSpace(class) {
+			case "upper":
+				policy.RequireUpper = true
+			case "lower":
+				policy.RequireLower = true
+			case "digit":
+				policy.RequireDigit = true
+			case "symbol":
+				policy.RequireSymbol = true
+			}
+		}
+
+		if policy.BreachedList != "" {
+			breachedHashes, breachedListErr = loadBreachedList(policy.BreachedList)
+		}
+
+		passwordPolicy = policy
+	})
+
+	return passwordPolicy
+}
+
+/*
+Loads the policy at startup so a PASSWORD_BREACHED_LIST that can't be read
+stops the server instead of silently turning the check off
+*/
+func InitializePasswordPolicy(connectionCh chan<- string) {
+	policy := GetPasswordPolicy()
+
+	if breachedListErr != nil {
+		log.Fatalf("Failed to load breached password list: %v", breachedListErr)
+	}
+
+	if policy.BreachedList != "" {
+		connectionCh <- "Breached password list loaded..."
+	}
+}
+
+/*
+Loads a file of SHA-1 hashes, one per line in the "HASH" or "HASH:COUNT"
+format of the Pwned Passwords downloads. Directories are read lazily so
+nil is returned for them.
+*/
+func loadBreachedList(path string) (map[string]struct{}, error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	hashes := map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+
+		if len(hash) == sha1.Size*2 {
+			hashes[strings.ToUpper(hash)] = struct{}{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded %v breached password hashes", len(hashes))
+	return hashes, nil
+}
+
+/*
+Checks the password against the local breach list. A directory is read as
+k-anonymity range files named after the first five hex characters of the
+hash and listing the remaining "SUFFIX:COUNT".
+*/
+func isBreachedPassword(policy PasswordPolicy, password string) bool {
+	if policy.BreachedList == "" {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if breachedHashes != nil {
+		_, found := breachedHashes[hash]
+		return found
+	}
+
+	file, err := os.Open(filepath.Join(policy.BreachedList, hash[:5]))
+
+	if err != nil {
+		return false
+	}
+
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		suffix, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+
+		if strings.EqualFold(suffix, hash[5:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Reports whether the password contains any word of the user's name or the
+local part of their email
+*/
+func containsPersonal(password string, personal []string) bool {
+	lowered := strings.ToLower(password)
+
+	for _, value := range personal {
+		local, _, _ := strings.Cut(strings.ToLower(value), "@")
+
+		for _, part := range strings.Fields(local) {
+			if len(part) >= 3 && strings.Contains(lowered, part) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+Checks a new password against the password policy. personal holds the
+user's name and email which the password must not contain.
+*/
+func ValidatePassword(field string, password string, personal ...string) ValidationErrors {
+	policy := GetPasswordPolicy()
+	var errs ValidationErrors
+
+	fail := func(message string) {
+		errs = append(errs, FieldError{Field: field, Message: message})
+	}
+
+	if len([]rune(password)) < policy.MinLength {
+		fail(fmt.Sprintf("must be at least %v characters", policy.MinLength))
+	}
+
+	if len(password) > policy.MaxLength {
+		fail(fmt.Sprintf("must be at most %v bytes", policy.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			upper = true
+		case unicode.IsLower(char):
+			lower = true
+		case unicode.IsDigit(char):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	if policy.RequireUpper && !upper {
+		fail("must contain an uppercase letter")
+	}
+
+	if policy.RequireLower && !lower {
+		fail("must contain a lowercase letter")
+	}
+
+	if policy.RequireDigit && !digit {
+		fail("must contain a digit")
+	}
+
+	if policy.RequireSymbol && !symbol {
+		fail("must contain a symbol")
+	}
+
+	if policy.DisallowPersonal && containsPersonal(password, personal) {
+		fail("must not contain your name or email")
+	}
+
+	if isBreachedPassword(policy, password) {
+		fail("has appeared in a data breach, choose a different one")
+	}
+
+	return errs
+}
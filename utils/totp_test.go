@@ -0,0 +1,59 @@
+package utils_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/saheemshafi/gin-basic-api/utils"
+)
+
+// The SHA1 seed of RFC 6238 appendix B
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// The RFC lists 8 digit codes, 6 digit ones are their last 6 digits
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, code := range vectors {
+		step, ok := utils.ValidateTOTP(rfc6238Secret, code, time.Unix(unix, 0))
+
+		if !ok || step != unix/30 {
+			t.Errorf("%v at %v matched step %v, %v", code, unix, step, ok)
+		}
+	}
+}
+
+func TestValidateTOTPRejects(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+
+	tests := map[string]struct {
+		code string
+		at   time.Time
+	}{
+		"two steps later":  {code: "050471", at: at.Add(time.Minute)},
+		"two steps before": {code: "050471", at: at.Add(-time.Minute)},
+		"wrong code":       {code: "050472", at: at},
+		"eight digits":     {code: "14050471", at: at},
+		"empty":            {code: "", at: at},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, ok := utils.ValidateTOTP(rfc6238Secret, test.code, test.at); ok {
+				t.Error("the code was accepted")
+			}
+		})
+	}
+
+	if _, ok := utils.ValidateTOTP("not base32!", "050471", at); ok {
+		t.Error("a code was accepted for a malformed secret")
+	}
+}
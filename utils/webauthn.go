@@ -0,0 +1,329 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	WebAuthnCreate = "webauthn.create"
+	WebAuthnGet    = "webauthn.get"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagBackedUp     = 0x10
+	flagAttested     = 0x40
+)
+
+/*
+Relying party settings. RPID is the domain passkeys are scoped to and
+Origins the exact origins the browser may report in client data.
+*/
+type WebAuthnConfig struct {
+	RPID    string
+	RPName  string
+	Origins []string
+}
+
+var (
+	webAuthnConfig     WebAuthnConfig
+	webAuthnConfigOnce sync.Once
+)
+
+/*
+Reads WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and the comma separated
+WEBAUTHN_ORIGINS once. Without origins the origin of APP_URL is used.
+*/
+func GetWebAuthnConfig() WebAuthnConfig {
+	webAuthnConfigOnce.Do(func() {
+		config := WebAuthnConfig{
+			RPID:   envOr("WEBAUTHN_RP_ID", "localhost"),
+			RPName: envOr("WEBAUTHN_RP_NAME", "Gin Basic Api"),
+		}
+
+		for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				config.Origins = append(config.Origins, strings.TrimSuffix(origin, "/"))
+			}
+		}
+
+		if len(config.Origins) == 0 {
+			if appURL, err := url.Parse(AppURL("")); err == nil {
+				config.Origins = []string{appURL.Scheme + "://" + appURL.Host}
+			}
+		}
+
+		webAuthnConfig = config
+	})
+
+	return webAuthnConfig
+}
+
+/*
+PublicKeyCredential as serialized by the browser's toJSON(), binary fields
+are base64url. Registration fills AttestationObject and Transports,
+authentication AuthenticatorData, Signature and UserHandle.
+*/
+type CredentialResponse struct {
+	Id       string `json:"id" binding:"required"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON" binding:"required"`
+		AttestationObject string   `json:"attestationObject"`
+		AuthenticatorData string   `json:"authenticatorData"`
+		Signature         string   `json:"signature"`
+		UserHandle        string   `json:"userHandle"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+/*
+Decodes the client data and checks it belongs to the given ceremony on one
+of our origins. The challenge is returned for the caller to look up.
+*/
+func ParseClientData(encoded string, ceremony string) (ClientData, error) {
+	var clientData ClientData
+
+	raw, err := DecodeBase64URL(encoded)
+
+	if err != nil {
+		return clientData, errors.New("client data isn't valid base64url")
+	}
+
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return clientData, errors.New("client data isn't valid json")
+	}
+
+	if clientData.Type != ceremony {
+		return clientData, fmt.Errorf("expected a %v ceremony", ceremony)
+	}
+
+	if clientData.CrossOrigin {
+		return clientData, errors.New("cross origin ceremonies aren't allowed")
+	}
+
+	for _, origin := range GetWebAuthnConfig().Origins {
+		if clientData.Origin == origin {
+			return clientData, nil
+		}
+	}
+
+	return clientData, fmt.Errorf("origin %v isn't allowed", clientData.Origin)
+}
+
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func (data AuthenticatorData) UserPresent() bool {
+	return data.Flags&flagUserPresent != 0
+}
+
+func (data AuthenticatorData) UserVerified() bool {
+	return data.Flags&flagUserVerified != 0
+}
+
+func (data AuthenticatorData) BackedUp() bool {
+	return data.Flags&flagBackedUp != 0
+}
+
+/*
+Parses authenticator data and checks it was made for our relying party
+with the user present, and verified when requireUV is set
+*/
+func parseAuthenticatorData(raw []byte, requireUV bool) (AuthenticatorData, error) {
+	var data AuthenticatorData
+
+	if len(raw) < 37 {
+		return data, errors.New("authenticator data is too short")
+	}
+
+	data.RPIDHash = raw[:32]
+	data.Flags = raw[32]
+	data.SignCount = binary.BigEndian.Uint32(raw[33:37])
+
+	rpIdHash := sha256.Sum256([]byte(GetWebAuthnConfig().RPID))
+
+	if subtle.ConstantTimeCompare(data.RPIDHash, rpIdHash[:]) != 1 {
+		return data, errors.New("credential belongs to another relying party")
+	}
+
+	if !data.UserPresent() {
+		return data, errors.New("user wasn't present")
+	}
+
+	if requireUV && !data.UserVerified() {
+		return data, errors.New("user wasn't verified")
+	}
+
+	if data.Flags&flagAttested == 0 {
+		return data, nil
+	}
+
+	// aaguid(16) | credential id length(2) | credential id | COSE key
+	rest := raw[37:]
+
+	if len(rest) < 18 {
+		return data, errors.New("attested credential data is too short")
+	}
+
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return data, errors.New("invalid credential id")
+	}
+
+	data.CredentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// The key is the only CBOR item whose length isn't written down
+	_, extensions, err := decodeCBOR(rest)
+
+	if err != nil {
+		return data, err
+	}
+
+	data.PublicKey = rest[:len(rest)-len(extensions)]
+
+	return data, nil
+}
+
+/*
+Credential created during registration, ready to be stored
+*/
+type RegisteredCredential struct {
+	Id        string
+	PublicKey []byte
+	Algorithm int
+	SignCount uint32
+	BackedUp  bool
+}
+
+/*
+Verifies the attestation object of a registration. Only the "none"
+attestation format is accepted, the client data has to be checked with
+ParseClientData first.
+*/
+func VerifyRegistration(credential CredentialResponse, requireUV bool) (RegisteredCredential, error) {
+	var registered RegisteredCredential
+
+	raw, err := DecodeBase64URL(credential.Response.AttestationObject)
+
+	if err != nil {
+		return registered, errors.New("attestation object isn't valid base64url")
+	}
+
+	decoded, _, err := decodeCBOR(raw)
+
+	if err != nil {
+		return registered, err
+	}
+
+	attestation, ok := decoded.(map[any]any)
+
+	if !ok {
+		return registered, errors.New("attestation object isn't a map")
+	}
+
+	if format, _ := attestation["fmt"].(string); format != "none" {
+		return registered, fmt.Errorf("unsupported attestation format %q", format)
+	}
+
+	authData, _ := attestation["authData"].([]byte)
+	data, err := parseAuthenticatorData(authData, requireUV)
+
+	if err != nil {
+		return registered, err
+	}
+
+	if data.CredentialID == nil {
+		return registered, errors.New("no credential was attested")
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(data.CredentialID)
+
+	if id != strings.TrimRight(credential.Id, "=") {
+		return registered, errors.New("credential id doesn't match the attested one")
+	}
+
+	key, err := ParseCOSEKey(data.PublicKey)
+
+	if err != nil {
+		return registered, err
+	}
+
+	return RegisteredCredential{
+		Id:        id,
+		PublicKey: data.PublicKey,
+		Algorithm: key.Algorithm,
+		SignCount: data.SignCount,
+		BackedUp:  data.BackedUp(),
+	}, nil
+}
+
+/*
+Verifies an authentication assertion against the stored COSE public key
+and returns the authenticator data so the caller can check the sign count
+*/
+func VerifyAssertion(credential CredentialResponse, publicKey []byte, requireUV bool) (AuthenticatorData, error) {
+	authData, authErr := DecodeBase64URL(credential.Response.AuthenticatorData)
+	clientData, clientErr := DecodeBase64URL(credential.Response.ClientDataJSON)
+	signature, signatureErr := DecodeBase64URL(credential.Response.Signature)
+
+	if errors.Join(authErr, clientErr, signatureErr) != nil {
+		return AuthenticatorData{}, errors.New("assertion isn't valid base64url")
+	}
+
+	data, err := parseAuthenticatorData(authData, requireUV)
+
+	if err != nil {
+		return data, err
+	}
+
+	key, err := ParseCOSEKey(publicKey)
+
+	if err != nil {
+		return data, err
+	}
+
+	clientDataHash := sha256.Sum256(clientData)
+	signed := bytes.Join([][]byte{authData, clientDataHash[:]}, nil)
+
+	if !key.Verify(signed, signature) {
+		return data, errors.New("invalid signature")
+	}
+
+	return data, nil
+}
+
+/*
+Decodes base64url with or without padding
+*/
+func DecodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
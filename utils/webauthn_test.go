@@ -0,0 +1,202 @@
+package utils_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/saheemshafi/gin-basic-api/utils"
+	"github.com/saheemshafi/gin-basic-api/utils/webauthntest"
+)
+
+func TestMain(m *testing.M) {
+	// The relying party config is read once, pin it to what the software
+	// authenticator uses by default
+	os.Setenv("WEBAUTHN_RP_ID", "localhost")
+	os.Setenv("WEBAUTHN_ORIGINS", "http://localhost:5000")
+
+	os.Exit(m.Run())
+}
+
+var algorithms = map[string]int{
+	"ES256": utils.COSEAlgES256,
+	"EdDSA": utils.COSEAlgEdDSA,
+}
+
+func newAuthenticator(t *testing.T, algorithm int) *webauthntest.Authenticator {
+	t.Helper()
+
+	authenticator, err := webauthntest.New(algorithm)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authenticator
+}
+
+func register(t *testing.T, authenticator *webauthntest.Authenticator) utils.RegisteredCredential {
+	t.Helper()
+
+	credential := authenticator.Register("challenge")
+
+	if _, err := utils.ParseClientData(credential.Response.ClientDataJSON, utils.WebAuthnCreate); err != nil {
+		t.Fatalf("client data: %v", err)
+	}
+
+	registered, err := utils.VerifyRegistration(credential, true)
+
+	if err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+
+	return registered
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	for name, algorithm := range algorithms {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newAuthenticator(t, algorithm)
+			registered := register(t, authenticator)
+
+			if registered.Id != authenticator.ID() || registered.Algorithm != algorithm {
+				t.Errorf("registered %v with algorithm %v", registered.Id, registered.Algorithm)
+			}
+
+			for i := uint32(1); i <= 2; i++ {
+				credential := authenticator.Assert("challenge")
+				clientData, err := utils.ParseClientData(credential.Response.ClientDataJSON, utils.WebAuthnGet)
+
+				if err != nil || clientData.Challenge != "challenge" {
+					t.Fatalf("client data %+v: %v", clientData, err)
+				}
+
+				data, err := utils.VerifyAssertion(credential, registered.PublicKey, true)
+
+				if err != nil {
+					t.Fatalf("assertion: %v", err)
+				}
+
+				if data.SignCount != i || !data.UserVerified() {
+					t.Errorf("sign count %v, user verified %v", data.SignCount, data.UserVerified())
+				}
+			}
+		})
+	}
+}
+
+func TestParseClientDataRejects(t *testing.T) {
+	tests := map[string]struct {
+		modify   func(authenticator *webauthntest.Authenticator)
+		ceremony string
+	}{
+		"wrong origin": {
+			modify:   func(authenticator *webauthntest.Authenticator) { authenticator.Origin = "https://attacker.example" },
+			ceremony: utils.WebAuthnGet,
+		},
+		"similar host": {
+			modify:   func(authenticator *webauthntest.Authenticator) { authenticator.Origin = "http://localhost:5001" },
+			ceremony: utils.WebAuthnGet,
+		},
+		"cross origin": {
+			modify:   func(authenticator *webauthntest.Authenticator) { authenticator.CrossOrigin = true },
+			ceremony: utils.WebAuthnGet,
+		},
+		"wrong ceremony": {
+			modify:   func(authenticator *webauthntest.Authenticator) {},
+			ceremony: utils.WebAuthnCreate,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newAuthenticator(t, utils.COSEAlgES256)
+			test.modify(authenticator)
+			credential := authenticator.Assert("challenge")
+
+			if _, err := utils.ParseClientData(credential.Response.ClientDataJSON, test.ceremony); err == nil {
+				t.Error("client data was accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	tests := map[string]func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse){
+		"wrong rp id hash": func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse) {
+			authenticator.RPID = "attacker.example"
+			*credential = authenticator.Register("challenge")
+		},
+		"user not present": func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse) {
+			authenticator.Flags = webauthntest.FlagUserVerified
+			*credential = authenticator.Register("challenge")
+		},
+		"user not verified": func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse) {
+			authenticator.Flags = webauthntest.FlagUserPresent
+			*credential = authenticator.Register("challenge")
+		},
+		"other credential id": func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse) {
+			credential.Id = "c29tZS1vdGhlci1pZA"
+		},
+		"garbage attestation": func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse) {
+			credential.Response.AttestationObject = "oWNmbXQ"
+		},
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newAuthenticator(t, utils.COSEAlgES256)
+			credential := authenticator.Register("challenge")
+			modify(authenticator, &credential)
+
+			if _, err := utils.VerifyRegistration(credential, true); err == nil {
+				t.Error("registration was accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	tests := map[string]func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse){
+		"wrong rp id hash": func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse) {
+			authenticator.RPID = "attacker.example"
+			*credential = authenticator.Assert("challenge")
+		},
+		"user not verified": func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse) {
+			authenticator.Flags = webauthntest.FlagUserPresent
+			*credential = authenticator.Assert("challenge")
+		},
+		"signed by another key": func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse) {
+			other := newAuthenticator(t, utils.COSEAlgES256)
+			credential.Response.Signature = other.Assert("challenge").Response.Signature
+		},
+		"tampered client data": func(authenticator *webauthntest.Authenticator, credential *utils.CredentialResponse) {
+			authenticator.Origin = "https://attacker.example"
+			credential.Response.ClientDataJSON = authenticator.Assert("challenge").Response.ClientDataJSON
+		},
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newAuthenticator(t, utils.COSEAlgES256)
+			registered := register(t, authenticator)
+			credential := authenticator.Assert("challenge")
+			modify(authenticator, &credential)
+
+			if _, err := utils.VerifyAssertion(credential, registered.PublicKey, true); err == nil {
+				t.Error("assertion was accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionWithoutUserVerification(t *testing.T) {
+	authenticator := newAuthenticator(t, utils.COSEAlgEdDSA)
+	registered := register(t, authenticator)
+	authenticator.Flags = webauthntest.FlagUserPresent
+
+	// Enough for a second factor, where the password already identified
+	// the user
+	if _, err := utils.VerifyAssertion(authenticator.Assert("challenge"), registered.PublicKey, false); err != nil {
+		t.Errorf("assertion without user verification was rejected: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Package webauthntest provides a software authenticator for exercising the
+passkey ceremonies without a browser, the way httptest does for servers.
+*/
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/saheemshafi/gin-basic-api/utils"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+	FlagAttested     = 0x40
+)
+
+/*
+Authenticator holding a single credential. Every field can be changed
+between ceremonies to produce the responses of a misbehaving or malicious
+authenticator.
+*/
+type Authenticator struct {
+	Algorithm    int
+	CredentialID []byte
+	// Returned as userHandle in assertions when set
+	UserHandle []byte
+	SignCount  uint32
+	// Counterless authenticators always report a sign count of zero
+	Counterless bool
+	Flags       byte
+	RPID        string
+	Origin      string
+	CrossOrigin bool
+
+	ecKey *ecdsa.PrivateKey
+	edKey ed25519.PrivateKey
+}
+
+/*
+Creates an authenticator for utils.COSEAlgES256 or utils.COSEAlgEdDSA that
+verifies the user and talks to the default relying party on localhost
+*/
+func New(algorithm int) (*Authenticator, error) {
+	authenticator := &Authenticator{
+		Algorithm:    algorithm,
+		CredentialID: make([]byte, 16),
+		Flags:        FlagUserPresent | FlagUserVerified,
+		RPID:         "localhost",
+		Origin:       "http://localhost:5000",
+	}
+
+	if _, err := rand.Read(authenticator.CredentialID); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	switch algorithm {
+	case utils.COSEAlgES256:
+		authenticator.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case utils.COSEAlgEdDSA:
+		_, authenticator.edKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported algorithm %v", algorithm)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return authenticator, nil
+}
+
+// Credential id the way relying parties store it
+func (authenticator *Authenticator) ID() string {
+	return base64.RawURLEncoding.EncodeToString(authenticator.CredentialID)
+}
+
+/*
+Answers navigator.credentials.create() with "none" attestation
+*/
+func (authenticator *Authenticator) Register(challenge string) utils.CredentialResponse {
+	authData := authenticator.authenticatorData(true)
+	attestation := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(authData),
+	)
+
+	var credential utils.CredentialResponse
+	credential.Id = authenticator.ID()
+	credential.Type = "public-key"
+	credential.Response.ClientDataJSON = authenticator.clientData(utils.WebAuthnCreate, challenge)
+	credential.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(attestation)
+	credential.Response.Transports = []string{"internal"}
+
+	return credential
+}
+
+/*
+Answers navigator.credentials.get(), moving the sign count forward first
+*/
+func (authenticator *Authenticator) Assert(challenge string) utils.CredentialResponse {
+	if !authenticator.Counterless {
+		authenticator.SignCount++
+	}
+
+	authData := authenticator.authenticatorData(false)
+	clientData := authenticator.clientData(utils.WebAuthnGet, challenge)
+	raw, _ := base64.RawURLEncoding.DecodeString(clientData)
+	clientDataHash := sha256.Sum256(raw)
+
+	var credential utils.CredentialResponse
+	credential.Id = authenticator.ID()
+	credential.Type = "public-key"
+	credential.Response.ClientDataJSON = clientData
+	credential.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	credential.Response.Signature = base64.RawURLEncoding.EncodeToString(
+		authenticator.sign(append(authData, clientDataHash[:]...)),
+	)
+
+	if authenticator.UserHandle != nil {
+		credential.Response.UserHandle = base64.RawURLEncoding.EncodeToString(authenticator.UserHandle)
+	}
+
+	return credential
+}
+
+func (authenticator *Authenticator) sign(message []byte) []byte {
+	if authenticator.edKey != nil {
+		return ed25519.Sign(authenticator.edKey, message)
+	}
+
+	digest := sha256.Sum256(message)
+	signature, err := ecdsa.SignASN1(rand.Reader, authenticator.ecKey, digest[:])
+
+	if err != nil {
+		panic(err)
+	}
+
+	return signature
+}
+
+func (authenticator *Authenticator) clientData(ceremony string, challenge string) string {
+	raw, _ := json.Marshal(utils.ClientData{
+		Type:        ceremony,
+		Challenge:   challenge,
+		Origin:      authenticator.Origin,
+		CrossOrigin: authenticator.CrossOrigin,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// rpIdHash(32) | flags(1) | signCount(4) | attested credential data
+func (authenticator *Authenticator) authenticatorData(attested bool) []byte {
+	rpIdHash := sha256.Sum256([]byte(authenticator.RPID))
+	flags := authenticator.Flags
+
+	if attested {
+		flags |= FlagAttested
+	}
+
+	data := append(rpIdHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, authenticator.SignCount)
+
+	if !attested {
+		return data
+	}
+
+	data = append(data, make([]byte, 16)...)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(authenticator.CredentialID)))
+	data = append(data, authenticator.CredentialID...)
+
+	return append(data, authenticator.PublicKey()...)
+}
+
+/*
+COSE encoding of the credential's public key
+*/
+func (authenticator *Authenticator) PublicKey() []byte {
+	if authenticator.edKey != nil {
+		return cborMap(
+			cborInt(1), cborInt(1),
+			cborInt(3), cborInt(utils.COSEAlgEdDSA),
+			cborInt(-1), cborInt(6),
+			cborInt(-2), cborBytes(authenticator.edKey.Public().(ed25519.PublicKey)),
+		)
+	}
+
+	public := authenticator.ecKey.PublicKey
+
+	return cborMap(
+		cborInt(1), cborInt(2),
+		cborInt(3), cborInt(utils.COSEAlgES256),
+		cborInt(-1), cborInt(1),
+		cborInt(-2), cborBytes(public.X.FillBytes(make([]byte, 32))),
+		cborInt(-3), cborBytes(public.Y.FillBytes(make([]byte, 32))),
+	)
+}
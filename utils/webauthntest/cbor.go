@@ -0,0 +1,45 @@
+package webauthntest
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Head of a CBOR item with the shortest encoding of its argument
+func cborHead(major byte, argument uint64) []byte {
+	major <<= 5
+
+	switch {
+	case argument < 24:
+		return []byte{major | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major | 26}, uint32(argument))
+	}
+
+	return binary.BigEndian.AppendUint64([]byte{major | 27}, argument)
+}
+
+func cborInt(value int64) []byte {
+	if value < 0 {
+		return cborHead(1, uint64(-1-value))
+	}
+
+	return cborHead(0, uint64(value))
+}
+
+func cborBytes(value []byte) []byte {
+	return append(cborHead(2, uint64(len(value))), value...)
+}
+
+func cborText(value string) []byte {
+	return append(cborHead(3, uint64(len(value))), value...)
+}
+
+// Keys and values alternate in entries
+func cborMap(entries ...[]byte) []byte {
+	return append(cborHead(5, uint64(len(entries)/2)), bytes.Join(entries, nil)...)
+}